@@ -0,0 +1,34 @@
+package api
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ApiKey identifies a client of the service. The key itself is only ever returned when it is created; the service
+// keeps a hash of it. Squads lists the squads a squad lead's key may manage.
+type ApiKey struct {
+	ID      ApiKeyId
+	Name    string
+	Role    Role      `json:",omitempty"`
+	Squads  []SquadId `json:",omitempty"`
+	Key     string    `json:",omitempty"`
+	Created time.Time
+	Revoked *time.Time `json:",omitempty"`
+}
+
+type ApiKeyId bson.ObjectId
+
+func (id ApiKeyId) String() string {
+	return bson.ObjectId(id).Hex()
+}
+
+func (id ApiKeyId) MarshalJSON() ([]byte, error) {
+	return bson.ObjectId(id).MarshalJSON()
+}
+
+func (id *ApiKeyId) UnmarshalJSON(data []byte) error {
+	objectId := (*bson.ObjectId)(id)
+	return objectId.UnmarshalJSON(data)
+}
@@ -10,6 +10,17 @@ import (
 type Squad struct {
 	ID      SquadId
 	Members []SquadMember
+	// MemberCount is only set when it was asked for.
+	MemberCount *int `json:",omitempty"`
+}
+
+// MarshalJSON writes a squad without members as having an empty list of them, rather than null.
+func (squad Squad) MarshalJSON() ([]byte, error) {
+	type plainSquad Squad
+	if squad.Members == nil {
+		squad.Members = []SquadMember{}
+	}
+	return json.Marshal(plainSquad(squad))
 }
 
 type SquadId bson.ObjectId
@@ -35,6 +46,10 @@ type SquadMember struct {
 
 type SquadMemberId bson.ObjectId
 
+func (id SquadMemberId) String() string {
+	return bson.ObjectId(id).Hex()
+}
+
 func (id SquadMemberId) MarshalJSON() ([]byte, error) {
 	return bson.ObjectId(id).MarshalJSON()
 }
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type EventType string
+
+const (
+	SquadCreated         EventType = "squad-created"
+	MemberUpserted       EventType = "member-upserted"
+	MemberRemoved        EventType = "member-removed"
+	SquadDeleted         EventType = "squad-deleted"
+	SquadListOverwritten EventType = "squad-list-overwritten"
+)
+
+var EventTypes = []EventType{
+	SquadCreated,
+	MemberUpserted,
+	MemberRemoved,
+	SquadDeleted,
+	SquadListOverwritten,
+}
+
+func (eventType EventType) IsValid() bool {
+	for _, known := range EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+type Event struct {
+	ID      string
+	Type    EventType
+	Time    time.Time
+	Tenant  string       `json:",omitempty"`
+	SquadID *SquadId     `json:",omitempty"`
+	Member  *SquadMember `json:",omitempty"`
+	Squads  []Squad      `json:",omitempty"`
+}
+
+func NewEvent(eventType EventType) Event {
+	return Event{
+		ID:   bson.NewObjectId().Hex(),
+		Type: eventType,
+		Time: time.Now().UTC(),
+	}
+}
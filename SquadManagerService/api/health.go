@@ -0,0 +1,24 @@
+package api
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	StatusUp          = "up"
+	StatusDown        = "down"
+)
+
+type Error struct {
+	Message   string
+	RequestID string
+}
+
+type Health struct {
+	Status       string
+	Dependencies map[string]DependencyHealth `json:",omitempty"`
+}
+
+type DependencyHealth struct {
+	Status   string
+	Duration string
+	Error    string `json:",omitempty"`
+}
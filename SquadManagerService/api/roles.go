@@ -0,0 +1,26 @@
+package api
+
+// Role decides what a caller may do: viewers read squads, squad leads also manage the members of the squads they lead,
+// and admins may do anything.
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleSquadLead Role = "squad-lead"
+	RoleAdmin     Role = "admin"
+)
+
+var Roles = []Role{
+	RoleViewer,
+	RoleSquadLead,
+	RoleAdmin,
+}
+
+func (role Role) IsValid() bool {
+	for _, known := range Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
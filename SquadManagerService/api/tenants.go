@@ -0,0 +1,11 @@
+package api
+
+import "time"
+
+// Tenant is an organisation whose squads are kept apart from every other tenant's. Its ID names it in URLs, host
+// names and tokens, so it is limited to lowercase letters, digits and dashes.
+type Tenant struct {
+	ID      string
+	Name    string
+	Created time.Time
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	WebhookSignatureHeader = "X-SquadManager-Signature"
+	WebhookEventHeader     = "X-SquadManager-Event"
+	WebhookDeliveryHeader  = "X-SquadManager-Delivery"
+)
+
+type Webhook struct {
+	ID     WebhookId
+	URL    string
+	Events []EventType `json:",omitempty"`
+	Secret string      `json:",omitempty"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type. A webhook with no events listed
+// subscribes to all of them.
+func (webhook Webhook) Subscribes(eventType EventType) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range webhook.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookId bson.ObjectId
+
+func (id WebhookId) String() string {
+	return bson.ObjectId(id).Hex()
+}
+
+func (id WebhookId) MarshalJSON() ([]byte, error) {
+	return bson.ObjectId(id).MarshalJSON()
+}
+
+func (id *WebhookId) UnmarshalJSON(data []byte) error {
+	objectId := (*bson.ObjectId)(id)
+	return objectId.UnmarshalJSON(data)
+}
+
+type WebhookDelivery struct {
+	ID         string
+	WebhookID  WebhookId
+	EventID    string
+	EventType  EventType
+	Attempt    int
+	StatusCode int
+	Error      string `json:",omitempty"`
+	Success    bool
+	Time       time.Time
+}
+
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, payload)), []byte(signature))
+}
@@ -0,0 +1,349 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type Client struct {
+	BaseURL    *url.URL
+	HTTPClient *http.Client
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+}
+
+func New(baseURL string) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("base URL %q must be absolute", baseURL)
+	}
+	return &Client{BaseURL: parsed, HTTPClient: http.DefaultClient}, nil
+}
+
+type QueryOption func(values url.Values)
+
+func Begin(begin time.Time) QueryOption {
+	return func(values url.Values) {
+		values.Set("begin", api.FormatDate(&begin))
+	}
+}
+
+func End(end time.Time) QueryOption {
+	return func(values url.Values) {
+		values.Set("end", api.FormatDate(&end))
+	}
+}
+
+// SortBy orders a squad listing by "created" or "members", descending when prefixed with a dash.
+func SortBy(sort string) QueryOption {
+	return func(values url.Values) {
+		values.Set("sort", sort)
+	}
+}
+
+// WithoutMembers lists squads without loading their members.
+func WithoutMembers() QueryOption {
+	return func(values url.Values) {
+		values.Set("members", "false")
+	}
+}
+
+// Include names what squads come with, out of "members" and "memberCount", in place of their members alone.
+func Include(embedded ...string) QueryOption {
+	return func(values url.Values) {
+		values.Set("include", strings.Join(embedded, ","))
+	}
+}
+
+// Fields limits the members of squads to the fields named, out of "ID", "Email" and "Range".
+func Fields(fields ...string) QueryOption {
+	return func(values url.Values) {
+		values.Set("fields", strings.Join(fields, ","))
+	}
+}
+
+func (client *Client) ListSquads(ctx context.Context, options ...QueryOption) ([]api.Squad, error) {
+	var squads []api.Squad
+	err := client.do(ctx, "GET", "/squad", options, nil, http.StatusOK, &squads)
+	return squads, err
+}
+
+// ListSquadPage lists up to limit squads, starting after the cursor returned with the previous page, or from the first
+// squad when the cursor is empty. The cursor returned is empty after the last page.
+func (client *Client) ListSquadPage(ctx context.Context, limit int, cursor string, options ...QueryOption) ([]api.Squad, string, error) {
+	options = append(options, func(values url.Values) {
+		values.Set("limit", strconv.Itoa(limit))
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+	})
+	var squads []api.Squad
+	header, err := client.exchange(ctx, "GET", "/squad", options, nil, http.StatusOK, &squads)
+	if err != nil {
+		return nil, "", err
+	}
+	return squads, nextCursor(header.Get("Link")), nil
+}
+
+// StreamSquads lists every squad a line at a time, handing each to each as it arrives rather than holding the whole
+// listing in memory. It stops at the first error each returns.
+func (client *Client) StreamSquads(ctx context.Context, each func(api.Squad) error, options ...QueryOption) error {
+	request, err := client.newRequest(ctx, "GET", "/squad", options, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/x-ndjson")
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return newResponseError(response)
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var squad api.Squad
+		if err := decoder.Decode(&squad); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := each(squad); err != nil {
+			return err
+		}
+	}
+}
+
+// nextCursor reads the cursor out of the next link of a page.
+func nextCursor(link string) string {
+	if !strings.HasPrefix(link, "<") || !strings.HasSuffix(link, `>; rel="next"`) {
+		return ""
+	}
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	if err != nil {
+		return ""
+	}
+	return next.Query().Get("cursor")
+}
+
+func (client *Client) GetSquad(ctx context.Context, squadId api.SquadId, options ...QueryOption) (*api.Squad, error) {
+	var squad api.Squad
+	if err := client.do(ctx, "GET", "/squad/"+squadId.String(), options, nil, http.StatusOK, &squad); err != nil {
+		return nil, err
+	}
+	return &squad, nil
+}
+
+func (client *Client) CreateSquad(ctx context.Context) (api.SquadId, error) {
+	var squadId api.SquadId
+	err := client.do(ctx, "POST", "/squad", nil, nil, http.StatusAccepted, &squadId)
+	return squadId, err
+}
+
+func (client *Client) OverwriteSquadList(ctx context.Context, squads []api.Squad) ([]api.Squad, error) {
+	var saved []api.Squad
+	err := client.do(ctx, "PUT", "/squad", nil, squads, http.StatusOK, &saved)
+	return saved, err
+}
+
+func (client *Client) UpsertSquadMember(ctx context.Context, squadId api.SquadId, member api.SquadMember) (api.SquadMemberId, error) {
+	var memberId api.SquadMemberId
+	err := client.do(ctx, "POST", "/squad/"+squadId.String(), nil, member, http.StatusAccepted, &memberId)
+	return memberId, err
+}
+
+func (client *Client) RemoveSquadMember(ctx context.Context, squadId api.SquadId, memberId api.SquadMemberId) error {
+	path := "/squad/" + squadId.String() + "/member/" + memberId.String()
+	return client.do(ctx, "DELETE", path, nil, nil, http.StatusNoContent, nil)
+}
+
+// DeleteSquad removes a squad and all of its members.
+func (client *Client) DeleteSquad(ctx context.Context, squadId api.SquadId) error {
+	return client.do(ctx, "DELETE", "/squad/"+squadId.String(), nil, nil, http.StatusNoContent, nil)
+}
+
+func (client *Client) ListApiKeys(ctx context.Context) ([]api.ApiKey, error) {
+	var apiKeys []api.ApiKey
+	err := client.do(ctx, "GET", "/apikey", nil, nil, http.StatusOK, &apiKeys)
+	return apiKeys, err
+}
+
+// CreateApiKey creates a key with the name, role and squads of the one given and returns it along with its secret,
+// which cannot be retrieved again.
+func (client *Client) CreateApiKey(ctx context.Context, apiKey api.ApiKey) (*api.ApiKey, error) {
+	var created api.ApiKey
+	if err := client.do(ctx, "POST", "/apikey", nil, apiKey, http.StatusAccepted, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (client *Client) RevokeApiKey(ctx context.Context, apiKeyId api.ApiKeyId) error {
+	return client.do(ctx, "DELETE", "/apikey/"+apiKeyId.String(), nil, nil, http.StatusNoContent, nil)
+}
+
+func (client *Client) ListTenants(ctx context.Context) ([]api.Tenant, error) {
+	var tenants []api.Tenant
+	err := client.do(ctx, "GET", "/tenant", nil, nil, http.StatusOK, &tenants)
+	return tenants, err
+}
+
+func (client *Client) CreateTenant(ctx context.Context, tenant api.Tenant) (*api.Tenant, error) {
+	var created api.Tenant
+	if err := client.do(ctx, "POST", "/tenant", nil, tenant, http.StatusAccepted, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteTenant removes a tenant along with all of its squads.
+func (client *Client) DeleteTenant(ctx context.Context, tenantId string) error {
+	return client.do(ctx, "DELETE", "/tenant/"+url.PathEscape(tenantId), nil, nil, http.StatusNoContent, nil)
+}
+
+func (client *Client) do(
+	ctx context.Context,
+	method string,
+	path string,
+	options []QueryOption,
+	body interface{},
+	expectedStatus int,
+	result interface{},
+) error {
+	_, err := client.exchange(ctx, method, path, options, body, expectedStatus, result)
+	return err
+}
+
+// exchange works like do, also returning the headers of the response.
+func (client *Client) exchange(
+	ctx context.Context,
+	method string,
+	path string,
+	options []QueryOption,
+	body interface{},
+	expectedStatus int,
+	result interface{},
+) (http.Header, error) {
+	request, err := client.newRequest(ctx, method, path, options, body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != expectedStatus {
+		return nil, newResponseError(response)
+	}
+
+	if result == nil {
+		return response.Header, nil
+	}
+	return response.Header, json.NewDecoder(response.Body).Decode(result)
+}
+
+func (client *Client) newRequest(ctx context.Context, method string, path string, options []QueryOption, body interface{}) (*http.Request, error) {
+	target := *client.BaseURL
+	target.Path = target.Path + path
+	values := url.Values{}
+	for _, option := range options {
+		option(values)
+	}
+	target.RawQuery = values.Encode()
+
+	var bodyReader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequest(method, target.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	if client.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+client.APIKey)
+	}
+	return request.WithContext(ctx), nil
+}
+
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *ResponseError) Error() string {
+	message := fmt.Sprintf("squad manager responded with %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	if err.Body != "" {
+		message += ": " + err.Body
+	}
+	return message
+}
+
+type NotFoundError struct {
+	ResponseError
+}
+
+type BadRequestError struct {
+	ResponseError
+}
+
+type UnauthorizedError struct {
+	ResponseError
+}
+
+type ForbiddenError struct {
+	ResponseError
+}
+
+type ServerError struct {
+	ResponseError
+}
+
+func newResponseError(response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	responseError := ResponseError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(body))}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return &NotFoundError{responseError}
+	case response.StatusCode == http.StatusBadRequest:
+		return &BadRequestError{responseError}
+	case response.StatusCode == http.StatusUnauthorized:
+		return &UnauthorizedError{responseError}
+	case response.StatusCode == http.StatusForbidden:
+		return &ForbiddenError{responseError}
+	case response.StatusCode >= http.StatusInternalServerError:
+		return &ServerError{responseError}
+	default:
+		return &responseError
+	}
+}
@@ -0,0 +1,187 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/client"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/service"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	config = service.Configuration{
+		DatabaseName: "SquadManagerClientTestDB",
+		Host:         "localhost",
+		DbTimeout:    time.Second,
+	}
+	server *httptest.Server
+)
+
+func TestMain(m *testing.M) {
+	mainHandler := service.MakeMainHandler(config)
+	server = httptest.NewServer(mainHandler)
+	retCode := m.Run()
+	server.Close()
+	mainHandler.Close()
+	os.Exit(retCode)
+}
+
+func newClient(t *testing.T, baseURL string) *client.Client {
+	squadClient, err := client.New(baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return squadClient
+}
+
+func TestCreateSquadWillIncludeSquadInGetSquad(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+
+	squadId, err := squadClient.CreateSquad(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	squad, err := squadClient.GetSquad(context.Background(), squadId)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &api.Squad{ID: squadId, Members: []api.SquadMember{}}, squad)
+}
+
+func TestUpsertSquadMemberWillShowMemberInListSquadsWithinDateRange(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	ctx := context.Background()
+	squadId, err := squadClient.CreateSquad(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inRange := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 8, 11), End: *api.Date(2017, 9, 15)})
+	outOfRange := api.NewSquadMember("chip@fake.com", api.Range{Begin: *api.Date(2017, 9, 20), End: *api.Date(2018, 2, 7)})
+
+	for _, member := range []api.SquadMember{inRange, outOfRange} {
+		memberId, err := squadClient.UpsertSquadMember(ctx, squadId, member)
+		assert.NoError(t, err)
+		assert.Equal(t, member.ID, memberId)
+	}
+	squads, err := squadClient.ListSquads(ctx, client.Begin(*api.Date(2017, 8, 11)), client.End(*api.Date(2017, 9, 15)))
+
+	assert.NoError(t, err)
+	assert.Contains(t, squads, api.Squad{ID: squadId, Members: []api.SquadMember{inRange}})
+}
+
+func TestOverwriteSquadListWillReplaceAllSquads(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	ctx := context.Background()
+	squadList := []api.Squad{
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+	}
+
+	saved, err := squadClient.OverwriteSquadList(ctx, squadList)
+	assert.NoError(t, err)
+	assert.Equal(t, squadList, saved)
+
+	squads, err := squadClient.ListSquads(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, squadList, squads)
+}
+
+func TestGetSquadWithUnknownSquadIdWillReturnNotFoundError(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+
+	squad, err := squadClient.GetSquad(context.Background(), api.SquadId(bson.NewObjectId()))
+
+	assert.Nil(t, squad)
+	assert.IsType(t, &client.NotFoundError{}, err)
+}
+
+func TestUpsertSquadMemberToUnknownSquadWillReturnNotFoundError(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	member := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 8, 11), End: *api.Date(2017, 9, 15)})
+
+	_, err := squadClient.UpsertSquadMember(context.Background(), api.SquadId(bson.NewObjectId()), member)
+
+	assert.IsType(t, &client.NotFoundError{}, err)
+}
+
+func TestListSquadsWillReturnServerErrorWhenDatasourceNotAvailable(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "SquadManagerClientTestDB",
+		Host:         "missing",
+		DbTimeout:    time.Millisecond / 100,
+	})
+	defer handler.Close()
+	missingServer := httptest.NewServer(handler)
+	defer missingServer.Close()
+
+	_, err := newClient(t, missingServer.URL).ListSquads(context.Background())
+
+	if assert.IsType(t, &client.ServerError{}, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, err.(*client.ServerError).StatusCode)
+	}
+}
+
+func TestListSquadsWillStopWhenContextIsCancelled(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := squadClient.ListSquads(ctx)
+
+	assert.Error(t, err)
+}
+
+func TestNewWillRejectRelativeBaseURL(t *testing.T) {
+	_, err := client.New("/squad")
+
+	assert.Error(t, err)
+}
+
+func TestListSquadPageWillFollowCursorsToTheLastPage(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	ctx := context.Background()
+	squadList := []api.Squad{
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+	}
+	if _, err := squadClient.OverwriteSquadList(ctx, squadList); err != nil {
+		t.Fatal(err)
+	}
+
+	first, cursor, err := squadClient.ListSquadPage(ctx, 2, "")
+	assert.NoError(t, err)
+	assert.Equal(t, squadList[:2], first)
+	assert.NotEmpty(t, cursor)
+
+	last, cursor, err := squadClient.ListSquadPage(ctx, 2, cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, squadList[2:], last)
+	assert.Empty(t, cursor)
+}
+
+func TestStreamSquadsWillHandOverEverySquadInOrder(t *testing.T) {
+	squadClient := newClient(t, server.URL)
+	ctx := context.Background()
+	squadList := []api.Squad{
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+		{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}},
+	}
+	if _, err := squadClient.OverwriteSquadList(ctx, squadList); err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed []api.Squad
+	err := squadClient.StreamSquads(ctx, func(squad api.Squad) error {
+		streamed = append(streamed, squad)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, squadList, streamed)
+}
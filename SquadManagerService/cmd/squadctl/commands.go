@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/client"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type commands struct {
+	ctx    context.Context
+	client *client.Client
+	out    output
+	stdin  io.Reader
+}
+
+func (command commands) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "squads":
+		if len(args) > 1 && args[1] == "list" {
+			return command.listSquads(args[2:])
+		}
+	case "squad":
+		if len(args) > 1 && args[1] == "get" {
+			return command.getSquad(args[2:])
+		}
+		if len(args) > 1 && args[1] == "create" {
+			return command.createSquad(args[2:])
+		}
+		if len(args) > 1 && args[1] == "delete" {
+			return command.deleteSquad(args[2:])
+		}
+	case "member":
+		if len(args) > 1 && args[1] == "add" {
+			return command.addMember(args[2:])
+		}
+		if len(args) > 1 && args[1] == "update" {
+			return command.updateMember(args[2:])
+		}
+		if len(args) > 1 && args[1] == "remove" {
+			return command.removeMember(args[2:])
+		}
+	case "export":
+		return command.exportSquads(args[1:])
+	case "import":
+		return command.importSquads(args[1:])
+	case "apikey":
+		if len(args) > 1 && args[1] == "list" {
+			return command.listApiKeys(args[2:])
+		}
+		if len(args) > 1 && args[1] == "create" {
+			return command.createApiKey(args[2:])
+		}
+		if len(args) > 1 && args[1] == "revoke" {
+			return command.revokeApiKey(args[2:])
+		}
+	case "tenant":
+		if len(args) > 1 && args[1] == "list" {
+			return command.listTenants(args[2:])
+		}
+		if len(args) > 1 && args[1] == "create" {
+			return command.createTenant(args[2:])
+		}
+		if len(args) > 1 && args[1] == "delete" {
+			return command.deleteTenant(args[2:])
+		}
+	}
+	return errUsage
+}
+
+func (command commands) listSquads(args []string) error {
+	flags, dates := newDateRangeFlags("squads list")
+	sort := flags.String("sort", "", "order squads by created or members, descending with a leading -")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	options, err := dates.queryOptions()
+	if err != nil {
+		return err
+	}
+	if *sort != "" {
+		options = append(options, client.SortBy(*sort))
+	}
+
+	squads, err := command.client.ListSquads(command.ctx, options...)
+	if err != nil {
+		return err
+	}
+	return command.out.printSquads(squads)
+}
+
+func (command commands) getSquad(args []string) error {
+	flags, dates := newDateRangeFlags("squad get")
+	squadId, err := parseSquadId(flags, args)
+	if err != nil {
+		return err
+	}
+	options, err := dates.queryOptions()
+	if err != nil {
+		return err
+	}
+
+	squad, err := command.client.GetSquad(command.ctx, squadId, options...)
+	if err != nil {
+		return err
+	}
+	return command.out.printSquads([]api.Squad{*squad})
+}
+
+func (command commands) createSquad(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	squadId, err := command.client.CreateSquad(command.ctx)
+	if err != nil {
+		return err
+	}
+	return command.out.printSquads([]api.Squad{{ID: squadId}})
+}
+
+func (command commands) deleteSquad(args []string) error {
+	squadId, err := parseSquadId(newFlagSet("squad delete"), args)
+	if err != nil {
+		return err
+	}
+
+	return command.client.DeleteSquad(command.ctx, squadId)
+}
+
+func (command commands) addMember(args []string) error {
+	flags := newFlagSet("member add")
+	email := flags.String("email", "", "member email")
+	begin := flags.String("begin", "", "first day of membership")
+	end := flags.String("end", "", "last day of membership")
+	squadId, err := parseSquadId(flags, args)
+	if err != nil {
+		return err
+	}
+	if *email == "" || *begin == "" || *end == "" {
+		return errUsage
+	}
+
+	member := api.NewSquadMember(*email, api.Range{})
+	if err := applyMemberChanges(&member, "", *begin, *end); err != nil {
+		return err
+	}
+
+	if _, err := command.client.UpsertSquadMember(command.ctx, squadId, member); err != nil {
+		return err
+	}
+	return command.out.printSquads([]api.Squad{{ID: squadId, Members: []api.SquadMember{member}}})
+}
+
+func (command commands) updateMember(args []string) error {
+	flags := newFlagSet("member update")
+	email := flags.String("email", "", "member email")
+	begin := flags.String("begin", "", "first day of membership")
+	end := flags.String("end", "", "last day of membership")
+	squadId, memberId, err := parseMemberId(flags, args)
+	if err != nil {
+		return err
+	}
+
+	member, err := command.findMember(squadId, memberId)
+	if err != nil {
+		return err
+	}
+	if err := applyMemberChanges(member, *email, *begin, *end); err != nil {
+		return err
+	}
+
+	if _, err := command.client.UpsertSquadMember(command.ctx, squadId, *member); err != nil {
+		return err
+	}
+	return command.out.printSquads([]api.Squad{{ID: squadId, Members: []api.SquadMember{*member}}})
+}
+
+func (command commands) removeMember(args []string) error {
+	squadId, memberId, err := parseMemberId(newFlagSet("member remove"), args)
+	if err != nil {
+		return err
+	}
+
+	return command.client.RemoveSquadMember(command.ctx, squadId, memberId)
+}
+
+func (command commands) findMember(squadId api.SquadId, memberId api.SquadMemberId) (*api.SquadMember, error) {
+	squad, err := command.client.GetSquad(command.ctx, squadId)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range squad.Members {
+		if member.ID == memberId {
+			return &member, nil
+		}
+	}
+	return nil, fmt.Errorf("squad %s has no member %s", squadId, memberId)
+}
+
+func (command commands) exportSquads(args []string) error {
+	flags := newFlagSet("export")
+	file := flags.String("file", "", "file to write to instead of standard output")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	squads, err := command.client.ListSquads(command.ctx)
+	if err != nil {
+		return err
+	}
+
+	writer := command.out.writer
+	if *file != "" {
+		created, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer created.Close()
+		writer = created
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(squads)
+}
+
+func (command commands) importSquads(args []string) error {
+	flags := newFlagSet("import")
+	file := flags.String("file", "", "file to read from instead of standard input")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	reader := command.stdin
+	if *file != "" {
+		opened, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+		reader = opened
+	}
+
+	var squads []api.Squad
+	if err := json.NewDecoder(reader).Decode(&squads); err != nil {
+		return fmt.Errorf("could not read squads: %v", err)
+	}
+
+	saved, err := command.client.OverwriteSquadList(command.ctx, squads)
+	if err != nil {
+		return err
+	}
+	return command.out.printSquads(saved)
+}
+
+func (command commands) listApiKeys(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	apiKeys, err := command.client.ListApiKeys(command.ctx)
+	if err != nil {
+		return err
+	}
+	return command.out.printApiKeys(apiKeys)
+}
+
+func (command commands) createApiKey(args []string) error {
+	flags := newFlagSet("apikey create")
+	role := flags.String("role", string(api.RoleViewer), "role granted to the key")
+	var squads squadIdsFlag
+	flags.Var(&squads, "squad", "squad a squad lead leads; may be repeated")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 1 {
+		return errUsage
+	}
+
+	requested := api.ApiKey{Name: positional[0], Role: api.Role(*role), Squads: squads}
+	apiKey, err := command.client.CreateApiKey(command.ctx, requested)
+	if err != nil {
+		return err
+	}
+	return command.out.printApiKeys([]api.ApiKey{*apiKey})
+}
+
+func (command commands) revokeApiKey(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if !bson.IsObjectIdHex(args[0]) {
+		return fmt.Errorf("%q is not a valid API key id", args[0])
+	}
+	return command.client.RevokeApiKey(command.ctx, api.ApiKeyId(bson.ObjectIdHex(args[0])))
+}
+
+func (command commands) listTenants(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	tenants, err := command.client.ListTenants(command.ctx)
+	if err != nil {
+		return err
+	}
+	return command.out.printTenants(tenants)
+}
+
+func (command commands) createTenant(args []string) error {
+	flags := newFlagSet("tenant create")
+	name := flags.String("name", "", "display name of the tenant")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 1 {
+		return errUsage
+	}
+
+	tenant, err := command.client.CreateTenant(command.ctx, api.Tenant{ID: positional[0], Name: *name})
+	if err != nil {
+		return err
+	}
+	return command.out.printTenants([]api.Tenant{*tenant})
+}
+
+func (command commands) deleteTenant(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return command.client.DeleteTenant(command.ctx, args[0])
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+// squadIdsFlag collects the squad ids of a repeated flag.
+type squadIdsFlag []api.SquadId
+
+func (squads *squadIdsFlag) String() string {
+	return fmt.Sprint([]api.SquadId(*squads))
+}
+
+func (squads *squadIdsFlag) Set(value string) error {
+	squadId, err := toSquadId(value)
+	if err != nil {
+		return err
+	}
+	*squads = append(*squads, squadId)
+	return nil
+}
+
+type dateRangeFlags struct {
+	begin *string
+	end   *string
+}
+
+func newDateRangeFlags(name string) (*flag.FlagSet, dateRangeFlags) {
+	flags := newFlagSet(name)
+	return flags, dateRangeFlags{
+		begin: flags.String("begin", "", "only include members active after this date"),
+		end:   flags.String("end", "", "only include members active before this date"),
+	}
+}
+
+func (dates dateRangeFlags) queryOptions() ([]client.QueryOption, error) {
+	var options []client.QueryOption
+	if *dates.begin != "" {
+		begin, err := parseDate(*dates.begin)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.Begin(begin))
+	}
+	if *dates.end != "" {
+		end, err := parseDate(*dates.end)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, client.End(end))
+	}
+	return options, nil
+}
+
+// parseSquadId parses the flags, which may come before or after the positional squad id.
+func parseSquadId(flags *flag.FlagSet, args []string) (api.SquadId, error) {
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 1 {
+		return "", errUsage
+	}
+	return toSquadId(positional[0])
+}
+
+func parseMemberId(flags *flag.FlagSet, args []string) (api.SquadId, api.SquadMemberId, error) {
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 2 {
+		return "", "", errUsage
+	}
+	squadId, err := toSquadId(positional[0])
+	if err != nil {
+		return "", "", err
+	}
+	if !bson.IsObjectIdHex(positional[1]) {
+		return "", "", fmt.Errorf("%q is not a valid member id", positional[1])
+	}
+	return squadId, api.SquadMemberId(bson.ObjectIdHex(positional[1])), nil
+}
+
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func toSquadId(value string) (api.SquadId, error) {
+	if !bson.IsObjectIdHex(value) {
+		return "", fmt.Errorf("%q is not a valid squad id", value)
+	}
+	return api.SquadId(bson.ObjectIdHex(value)), nil
+}
+
+func applyMemberChanges(member *api.SquadMember, email string, begin string, end string) error {
+	if email != "" {
+		member.Email = email
+	}
+	if begin != "" {
+		date, err := parseDate(begin)
+		if err != nil {
+			return err
+		}
+		member.Range.Begin = date
+	}
+	if end != "" {
+		date, err := parseDate(end)
+		if err != nil {
+			return err
+		}
+		member.Range.End = date
+	}
+	return nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	date, err := api.ParseDate(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a valid date", value)
+	}
+	return *date, nil
+}
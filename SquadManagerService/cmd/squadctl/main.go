@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/client"
+)
+
+const usage = `Usage: squadctl [--url URL] [--api-key KEY] [--output table|json|csv] [--timeout DURATION] <command> [arguments]
+
+Commands:
+  squads list [--begin DATE] [--end DATE] [--sort created|members|-created|-members]
+  squad get <squadId> [--begin DATE] [--end DATE]
+  squad create
+  squad delete <squadId>
+  member add <squadId> --email EMAIL --begin DATE --end DATE
+  member update <squadId> <memberId> [--email EMAIL] [--begin DATE] [--end DATE]
+  member remove <squadId> <memberId>
+  export [--file PATH]
+  import [--file PATH]
+  apikey list
+  apikey create <name> [--role viewer|squad-lead|admin] [--squad SQUADID]...
+  apikey revoke <apiKeyId>
+  tenant list
+  tenant create <tenantId> [--name NAME]
+  tenant delete <tenantId>
+
+Dates are RFC3339 timestamps or YYYY-MM-DD. To work within a tenant, include its path in the URL, such as
+http://localhost:8080/tenants/acme. The URL defaults to $SQUADCTL_URL, or http://localhost:8080, and the
+API key to $SQUADCTL_API_KEY.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if err == errUsage {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "squadctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("squadctl", flag.ContinueOnError)
+	flags.Usage = func() {}
+	baseURL := flags.String("url", defaultURL(), "base URL of the squad manager service")
+	apiKey := flags.String("api-key", os.Getenv("SQUADCTL_API_KEY"), "API key to authenticate with")
+	format := flags.String("output", "table", "output format: table, json or csv")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout for each command")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	out, err := newOutput(*format, stdout)
+	if err != nil {
+		return err
+	}
+
+	squadClient, err := client.New(*baseURL)
+	if err != nil {
+		return err
+	}
+	squadClient.APIKey = *apiKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	command := commands{ctx: ctx, client: squadClient, out: out, stdin: stdin}
+	return command.run(flags.Args())
+}
+
+func defaultURL() string {
+	if url := os.Getenv("SQUADCTL_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
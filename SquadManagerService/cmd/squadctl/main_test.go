@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSquadsListWillPrintOneCsvRowPerMember(t *testing.T) {
+	squadId := api.SquadId(bson.NewObjectId())
+	member := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 11, 10)})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/squad", request.URL.Path)
+		assert.Equal(t, "2017-08-01T00:00:00Z", request.URL.Query().Get("begin"))
+		json.NewEncoder(writer).Encode([]api.Squad{{ID: squadId, Members: []api.SquadMember{member}}})
+	}))
+	defer server.Close()
+	stdout := &bytes.Buffer{}
+
+	err := run([]string{"--url", server.URL, "--output", "csv", "squads", "list", "--begin", "2017-08-01"}, nil, stdout)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "SQUAD,MEMBER,EMAIL,BEGIN,END\n"+
+		squadId.String()+","+member.ID.String()+",dale@fake.com,2017-07-30T00:00:00Z,2017-11-10T00:00:00Z\n",
+		stdout.String())
+}
+
+func TestMemberUpdateWillOnlyChangeGivenFields(t *testing.T) {
+	squadId := api.SquadId(bson.NewObjectId())
+	member := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 11, 10)})
+	var upserted api.SquadMember
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			json.NewEncoder(writer).Encode(api.Squad{ID: squadId, Members: []api.SquadMember{member}})
+			return
+		}
+		json.NewDecoder(request.Body).Decode(&upserted)
+		writer.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(writer).Encode(upserted.ID)
+	}))
+	defer server.Close()
+
+	err := run([]string{"--url", server.URL, "member", "update", squadId.String(), member.ID.String(), "--end", "2017-12-01"}, nil, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	member.Range.End = *api.Date(2017, 12, 1)
+	assert.Equal(t, member, upserted)
+}
+
+func TestImportWillOverwriteSquadListFromStandardInput(t *testing.T) {
+	squads := []api.Squad{{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}}}
+	var overwritten []api.Squad
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "PUT", request.Method)
+		json.NewDecoder(request.Body).Decode(&overwritten)
+		json.NewEncoder(writer).Encode(overwritten)
+	}))
+	defer server.Close()
+	stdin, _ := json.Marshal(squads)
+
+	err := run([]string{"--url", server.URL, "import"}, bytes.NewReader(stdin), &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, squads, overwritten)
+}
+
+func TestUnknownCommandWillReturnUsageError(t *testing.T) {
+	err := run([]string{"squads", "explode"}, nil, &bytes.Buffer{})
+
+	assert.Equal(t, errUsage, err)
+}
+
+func TestUnknownOutputFormatWillError(t *testing.T) {
+	err := run([]string{"--output", "yaml", "squads", "list"}, nil, &bytes.Buffer{})
+
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "yaml"))
+	}
+}
+
+func TestApiKeyCreateWillAuthenticateAndPrintTheNewKey(t *testing.T) {
+	squadId := api.SquadId(bson.ObjectIdHex("5a0e6b1bd2b0f6f2a5c3a7e2"))
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "POST", request.Method)
+		assert.Equal(t, "/apikey", request.URL.Path)
+		assert.Equal(t, "Bearer admin-key", request.Header.Get("Authorization"))
+		var requested api.ApiKey
+		json.NewDecoder(request.Body).Decode(&requested)
+		assert.Equal(t, api.ApiKey{Name: "reporting", Role: api.RoleSquadLead, Squads: []api.SquadId{squadId}}, requested)
+
+		requested.ID = api.ApiKeyId(bson.ObjectIdHex("5a0e6b1bd2b0f6f2a5c3a7e1"))
+		requested.Key = "sqm_new"
+		writer.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(writer).Encode(requested)
+	}))
+	defer server.Close()
+	stdout := &bytes.Buffer{}
+
+	err := run([]string{"--url", server.URL, "--api-key", "admin-key", "--output", "csv", "apikey", "create", "reporting", "--role", "squad-lead", "--squad", squadId.String()}, nil, stdout)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ID,NAME,ROLE,SQUADS,CREATED,REVOKED,KEY\n"+
+		"5a0e6b1bd2b0f6f2a5c3a7e1,reporting,squad-lead,5a0e6b1bd2b0f6f2a5c3a7e2,0001-01-01T00:00:00Z,,sqm_new\n",
+		stdout.String())
+}
+
+func TestSquadsListWillServeTheTenantNamedInTheUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/tenants/acme/squad", request.URL.Path)
+		json.NewEncoder(writer).Encode([]api.Squad{})
+	}))
+	defer server.Close()
+
+	err := run([]string{"--url", server.URL + "/tenants/acme", "squads", "list"}, nil, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type output struct {
+	format string
+	writer io.Writer
+}
+
+func newOutput(format string, writer io.Writer) (output, error) {
+	switch format {
+	case "table", "json", "csv":
+		return output{format, writer}, nil
+	}
+	return output{}, fmt.Errorf("unknown output format %q", format)
+}
+
+var squadColumns = []string{"SQUAD", "MEMBER", "EMAIL", "BEGIN", "END"}
+
+func (out output) printSquads(squads []api.Squad) error {
+	switch out.format {
+	case "json":
+		encoder := json.NewEncoder(out.writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(squads)
+	case "csv":
+		writer := csv.NewWriter(out.writer)
+		writer.Write(squadColumns)
+		writer.WriteAll(squadRows(squads))
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(out.writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(squadColumns, "\t"))
+		for _, row := range squadRows(squads) {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+}
+
+var apiKeyColumns = []string{"ID", "NAME", "ROLE", "SQUADS", "CREATED", "REVOKED", "KEY"}
+
+func (out output) printApiKeys(apiKeys []api.ApiKey) error {
+	switch out.format {
+	case "json":
+		encoder := json.NewEncoder(out.writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(apiKeys)
+	case "csv":
+		writer := csv.NewWriter(out.writer)
+		writer.Write(apiKeyColumns)
+		writer.WriteAll(apiKeyRows(apiKeys))
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(out.writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(apiKeyColumns, "\t"))
+		for _, row := range apiKeyRows(apiKeys) {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+}
+
+func apiKeyRows(apiKeys []api.ApiKey) [][]string {
+	rows := [][]string{}
+	for _, apiKey := range apiKeys {
+		squads := []string{}
+		for _, squadId := range apiKey.Squads {
+			squads = append(squads, squadId.String())
+		}
+		revoked := ""
+		if apiKey.Revoked != nil {
+			revoked = api.FormatDate(apiKey.Revoked)
+		}
+		rows = append(rows, []string{
+			apiKey.ID.String(),
+			apiKey.Name,
+			string(apiKey.Role),
+			strings.Join(squads, " "),
+			api.FormatDate(&apiKey.Created),
+			revoked,
+			apiKey.Key,
+		})
+	}
+	return rows
+}
+
+var tenantColumns = []string{"ID", "NAME", "CREATED"}
+
+func (out output) printTenants(tenants []api.Tenant) error {
+	switch out.format {
+	case "json":
+		encoder := json.NewEncoder(out.writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tenants)
+	case "csv":
+		writer := csv.NewWriter(out.writer)
+		writer.Write(tenantColumns)
+		writer.WriteAll(tenantRows(tenants))
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(out.writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(tenantColumns, "\t"))
+		for _, row := range tenantRows(tenants) {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+}
+
+func tenantRows(tenants []api.Tenant) [][]string {
+	rows := [][]string{}
+	for _, tenant := range tenants {
+		rows = append(rows, []string{tenant.ID, tenant.Name, api.FormatDate(&tenant.Created)})
+	}
+	return rows
+}
+
+// squadRows flattens the squads into one row per member, with a single row for squads that have no members.
+func squadRows(squads []api.Squad) [][]string {
+	rows := [][]string{}
+	for _, squad := range squads {
+		if len(squad.Members) == 0 {
+			rows = append(rows, []string{squad.ID.String(), "", "", "", ""})
+		}
+		for _, member := range squad.Members {
+			rows = append(rows, []string{
+				squad.ID.String(),
+				member.ID.String(),
+				member.Email,
+				api.FormatDate(&member.Range.Begin),
+				api.FormatDate(&member.Range.End),
+			})
+		}
+	}
+	return rows
+}
@@ -1,21 +1,65 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/service"
 	"github.com/urfave/negroni"
 )
 
 func main() {
-	handler := service.MakeMainHandler(service.Configuration{
-		Host:         "localhost",
-		DatabaseName: "SquadManager",
-		DbTimeout:    1000,
-	})
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+
+	config, err := service.LoadConfiguration(flags, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *printConfig {
+		if err := service.WriteConfiguration(os.Stdout, config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	handler := service.MakeMainHandler(config)
+
+	logger := handler.Logger()
+	if !config.Auth.Enabled {
+		logger.Warn("authentication is disabled, so every route is open to anyone; set auth.adminKey or auth.jwt.jwks to require credentials")
+	}
 
-	defer handler.Close()
+	if config.Health.FailFast {
+		if health := handler.CheckDependencies(); health.Status != api.StatusOK {
+			handler.Close()
+			logger.Error("dependencies are not available", "dependencies", health.Dependencies)
+			os.Exit(1)
+		}
+	}
 
-	classic := negroni.Classic()
-	classic.UseHandler(handler)
+	recovery := negroni.NewRecovery()
+	recovery.Logger = logger.StandardLogger(service.LevelError)
+	server := negroni.New(recovery)
+	server.UseHandler(handler)
 
-	classic.Run(":8080")
+	logger.Info("listening", "address", listener.Addr())
+	if err := service.ServeUntilSignalled(listener, server, handler, config.ShutdownTimeout, signals); err != nil {
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (repository SquadRepository) ApiKeyCollection() *mgo.Collection {
+	return repository.Database().C("apiKey")
+}
+
+func (repository SquadRepository) addApiKey(ctx context.Context, apiKey api.ApiKey, hash string) (api.ApiKey, error) {
+	apiKey.ID = api.ApiKeyId(bson.NewObjectId())
+	apiKey.Created = time.Now().UTC()
+	return apiKey, repository.write(ctx, func(repository SquadRepository) error {
+		return repository.ApiKeyCollection().Insert(toApiKeyDocument(apiKey, hash))
+	})
+}
+
+func (repository SquadRepository) listApiKeys(ctx context.Context) ([]api.ApiKey, error) {
+	var documents []ApiKeyDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.ApiKeyCollection().Find(bson.M{}).Sort("created").All(&documents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := make([]api.ApiKey, len(documents))
+	for index, document := range documents {
+		apiKeys[index] = toApiApiKey(document)
+	}
+	return apiKeys, nil
+}
+
+// findApiKey looks up a key that has not been revoked by the hash of its secret.
+func (repository SquadRepository) findApiKey(ctx context.Context, hash string) (*api.ApiKey, error) {
+	var document ApiKeyDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.ApiKeyCollection().Find(bson.M{"hash": hash, "revoked": nil}).One(&document)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	apiKey := toApiApiKey(document)
+	return &apiKey, nil
+}
+
+func (repository SquadRepository) revokeApiKey(ctx context.Context, idString string) (bool, error) {
+	if !bson.IsObjectIdHex(idString) {
+		return false, nil
+	}
+
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		query := bson.M{"_id": bson.ObjectIdHex(idString), "revoked": nil}
+		return repository.ApiKeyCollection().Update(query, bson.M{"$set": bson.M{"revoked": time.Now().UTC()}})
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func toApiKeyDocument(apiKey api.ApiKey, hash string) ApiKeyDocument {
+	document := ApiKeyDocument{
+		ID:      bson.ObjectId(apiKey.ID),
+		Name:    apiKey.Name,
+		Role:    string(apiKey.Role),
+		Hash:    hash,
+		Created: apiKey.Created,
+		Revoked: apiKey.Revoked,
+	}
+	for _, squadId := range apiKey.Squads {
+		document.Squads = append(document.Squads, bson.ObjectId(squadId))
+	}
+	return document
+}
+
+func toApiApiKey(document ApiKeyDocument) api.ApiKey {
+	apiKey := api.ApiKey{
+		ID:      api.ApiKeyId(document.ID),
+		Name:    document.Name,
+		Role:    api.Role(document.Role),
+		Created: document.Created.UTC(),
+	}
+	if apiKey.Role == "" {
+		// Keys stored before they had roles are viewers, as keys created without one are.
+		apiKey.Role = api.RoleViewer
+	}
+	for _, squadId := range document.Squads {
+		apiKey.Squads = append(apiKey.Squads, api.SquadId(squadId))
+	}
+	if document.Revoked != nil {
+		revoked := document.Revoked.UTC()
+		apiKey.Revoked = &revoked
+	}
+	return apiKey
+}
+
+type ApiKeyDocument struct {
+	ID      bson.ObjectId   `bson:"_id,omitempty"`
+	Name    string          `bson:"name"`
+	Role    string          `bson:"role"`
+	Squads  []bson.ObjectId `bson:"squads,omitempty"`
+	Hash    string          `bson:"hash"`
+	Created time.Time       `bson:"created"`
+	Revoked *time.Time      `bson:"revoked"`
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+func listApiKeys(ctx context.Context, _ *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	apiKeys, err := repository.listApiKeys(ctx)
+	return ResponseEntity{apiKeys, http.StatusOK}, err
+}
+
+func createApiKey(ctx context.Context, request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	var apiKey api.ApiKey
+	if rejected := decodeBody(request, &apiKey); rejected != nil {
+		return *rejected, nil
+	}
+	apiKey.Name = strings.TrimSpace(apiKey.Name)
+	if apiKey.Name == "" {
+		return ResponseEntity{errors.New("API key name must not be empty"), http.StatusBadRequest}, nil
+	}
+	if err := validateApiKeyRole(&apiKey); err != nil {
+		return ResponseEntity{err, http.StatusBadRequest}, nil
+	}
+
+	key, err := generateApiKey()
+	if err != nil {
+		return ResponseEntity{}, err
+	}
+	apiKey.Revoked = nil
+	apiKey, err = repository.addApiKey(ctx, apiKey, hashApiKey(key))
+	apiKey.Key = key
+	return ResponseEntity{apiKey, http.StatusAccepted}, err
+}
+
+func revokeApiKey(ctx context.Context, _ *http.Request, params httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	revoked, err := repository.revokeApiKey(ctx, params.ByName("id"))
+	if err != nil || !revoked {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+	return ResponseEntity{code: http.StatusNoContent}, nil
+}
+
+// validateApiKeyRole makes keys without a role viewers, and insists that only squad leads, and every squad lead, name
+// the squads they lead.
+func validateApiKeyRole(apiKey *api.ApiKey) error {
+	if apiKey.Role == "" {
+		apiKey.Role = api.RoleViewer
+	}
+	if !apiKey.Role.IsValid() {
+		return fmt.Errorf("unknown role %q", apiKey.Role)
+	}
+	if apiKey.Role == api.RoleSquadLead && len(apiKey.Squads) == 0 {
+		return errors.New("squad lead keys must name the squads they lead")
+	}
+	if apiKey.Role != api.RoleSquadLead && len(apiKey.Squads) != 0 {
+		return errors.New("only squad lead keys may name squads")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+const apiKeyPrefix = "sqm_"
+
+type AuthConfiguration struct {
+	Enabled  bool
+	AdminKey string
+	Jwt      JwtConfiguration
+}
+
+func (config AuthConfiguration) withDefaults() AuthConfiguration {
+	config.Jwt = config.Jwt.withDefaults()
+	return config
+}
+
+// enabledByCredentials turns authentication on when an admin key or an identity provider is configured, as callers
+// are then expected to present them.
+func (config AuthConfiguration) enabledByCredentials() AuthConfiguration {
+	if config.AdminKey != "" || config.Jwt.Jwks != "" {
+		config.Enabled = true
+	}
+	return config
+}
+
+// Identity is the caller a request was authenticated as. KeyID is only set for API keys stored in the database, and
+// Squads holds the ids of the squads a squad lead leads. Tenant is the tenant the caller belongs to, if any.
+type Identity struct {
+	KeyID  string
+	Name   string
+	Tenant string
+	Roles  []api.Role
+	Squads []string
+}
+
+func (identity *Identity) hasRole(role api.Role) bool {
+	for _, held := range identity.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (identity *Identity) leads(squadId string) bool {
+	return identity.hasRole(api.RoleSquadLead) && containsString(identity.Squads, squadId)
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity the request was authenticated as, or nil when authentication is disabled or the
+// route is public.
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// authorize checks the bearer key of each request against the permission the route requires before handing it on,
+// with the caller's identity in its context. A nil permission leaves the route open to anyone.
+func (service *Context) authorize(allowed permission, next httprouter.Handle) httprouter.Handle {
+	if allowed == nil {
+		return next
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if !service.Auth.Enabled {
+			next(writer, request, params)
+			return
+		}
+
+		identity, err := service.identify(request)
+		if err != nil {
+			service.Logger.forRequest(request).Error("authentication failed", "route", routeTemplate(request), "error", err)
+			respond(writer, request, ResponseEntity{code: errorStatus(err)})
+			return
+		}
+		if identity == nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="squadmanager"`)
+			respond(writer, request, ResponseEntity{code: http.StatusUnauthorized})
+			return
+		}
+		if service.Tenancy.Mode == "claim" && identity.Tenant != "" {
+			request = withTenant(request, identity.Tenant)
+		}
+		if !service.inTenant(identity, request) || !allowed(identity, params) {
+			respond(writer, request, ResponseEntity{code: http.StatusForbidden})
+			return
+		}
+
+		auditIdentity(request, identity)
+		next(writer, request.WithContext(context.WithValue(request.Context(), identityKey{}, identity)), params)
+	}
+}
+
+// identify resolves the bearer token on the request, which is either a JWT from the identity provider or an API key,
+// returning nil for a missing, invalid, unknown or revoked one. API keys are looked up among those of the request's
+// tenant.
+func (service *Context) identify(request *http.Request) (*Identity, error) {
+	key := bearerToken(request)
+	if key == "" {
+		return nil, nil
+	}
+
+	if service.Tokens != nil && looksLikeJwt(key) {
+		identity, err := service.Tokens.Verify(key)
+		if err == errInvalidToken {
+			return nil, nil
+		}
+		return identity, err
+	}
+
+	hash := hashApiKey(key)
+	if service.Auth.AdminKey != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(hashApiKey(service.Auth.AdminKey))) == 1 {
+		return &Identity{Name: "admin", Roles: []api.Role{api.RoleAdmin}}, nil
+	}
+
+	ctx, cancel := withRequestTimeout(request.Context(), service.RequestTimeout)
+	defer cancel()
+
+	tenant := TenantFrom(request.Context())
+	repository, err := service.RepositoryFactory.TenantRepository(tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	apiKey, err := repository.findApiKey(ctx, hash)
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+	return apiKeyIdentity(*apiKey, tenant), nil
+}
+
+func apiKeyIdentity(apiKey api.ApiKey, tenant string) *Identity {
+	identity := &Identity{KeyID: apiKey.ID.String(), Name: apiKey.Name, Tenant: tenant, Roles: []api.Role{apiKey.Role}}
+	for _, squadId := range apiKey.Squads {
+		identity.Squads = append(identity.Squads, squadId.String())
+	}
+	return identity
+}
+
+func bearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// hashApiKey hashes a key for storage. Keys are long and random, so a fast unsalted hash is enough to keep a copy of
+// the database from granting access.
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateApiKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+type auditKey struct{}
+
+type audit struct {
+	identity *Identity
+	tenant   string
+}
+
+func (audit *audit) subject() string {
+	if audit.identity == nil {
+		return ""
+	}
+	return audit.identity.Name
+}
+
+// withAudit lets the request log learn who the caller was once the route has authenticated them.
+func withAudit(request *http.Request) (*http.Request, *audit) {
+	if existing, ok := request.Context().Value(auditKey{}).(*audit); ok {
+		return request, existing
+	}
+	created := &audit{}
+	return request.WithContext(context.WithValue(request.Context(), auditKey{}, created)), created
+}
+
+func auditTenant(request *http.Request, tenant string) {
+	if existing, ok := request.Context().Value(auditKey{}).(*audit); ok {
+		existing.tenant = tenant
+	}
+}
+
+func auditIdentity(request *http.Request, identity *Identity) {
+	if existing, ok := request.Context().Value(auditKey{}).(*audit); ok {
+		existing.identity = identity
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthTestContext(auth AuthConfiguration) *Context {
+	return &Context{
+		RepositoryFactory: &SquadRepositoryFactory{Config: Configuration{Host: "missing", DbTimeout: 1}},
+		Logger:            newDefaultLogger(LoggingConfiguration{Level: "error"}),
+		Auth:              auth,
+	}
+}
+
+func identityRecorder(seen **Identity) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		*seen = IdentityFrom(request.Context())
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestAuthorizeWillPassEveryRequestWhenDisabled(t *testing.T) {
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{}).authorize(isAdmin, identityRecorder(&seen))
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, httptest.NewRequest("PUT", "/squad", nil), nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Nil(t, seen)
+}
+
+func TestAuthorizeWillChallengeRequestsWithoutABearerKey(t *testing.T) {
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{Enabled: true}).authorize(canView, identityRecorder(&seen))
+	request := httptest.NewRequest("PUT", "/squad", nil)
+	request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, request, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer realm="squadmanager"`, recorder.Header().Get("WWW-Authenticate"))
+	assert.Nil(t, seen)
+}
+
+func TestAuthorizeWillAcceptTheBootstrapAdminKey(t *testing.T) {
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{Enabled: true, AdminKey: "s3cret"}).authorize(isAdmin, identityRecorder(&seen))
+	request := httptest.NewRequest("POST", "/apikey", nil)
+	request.Header.Set("Authorization", "bearer s3cret")
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, request, nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, &Identity{Name: "admin", Roles: []api.Role{api.RoleAdmin}}, seen)
+}
+
+func TestAuthorizeWillReportUnreachableKeyStorage(t *testing.T) {
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{Enabled: true, AdminKey: "s3cret"}).authorize(canView, identityRecorder(&seen))
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set("Authorization", "Bearer sqm_unknown")
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, request, nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Nil(t, seen)
+}
+
+func TestAuthorizeWillLeavePublicRoutesOpen(t *testing.T) {
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{Enabled: true}).authorize(nil, identityRecorder(&seen))
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, httptest.NewRequest("GET", "/healthz", nil), nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestRequestLogWillNameTheAuthenticatedCaller(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, _ := NewLogger(output, LoggingConfiguration{Format: "logfmt"})
+	var seen *Identity
+	handle := newAuthTestContext(AuthConfiguration{Enabled: true, AdminKey: "s3cret"}).authorize(isAdmin, identityRecorder(&seen))
+	handler := WithRequestId(LogRequest(logger, func(writer http.ResponseWriter, request *http.Request) {
+		handle(writer, request, nil)
+	}))
+	request := httptest.NewRequest("GET", "/apikey", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+
+	handler(httptest.NewRecorder(), request)
+
+	assert.Contains(t, output.String(), " subject=admin ")
+}
+
+func TestGeneratedApiKeysWillBeDistinctAndStoredOnlyAsHashes(t *testing.T) {
+	first, err := generateApiKey()
+	assert.Nil(t, err)
+	second, _ := generateApiKey()
+
+	assert.NotEqual(t, first, second)
+	assert.Len(t, first, len(apiKeyPrefix)+64)
+	assert.Len(t, hashApiKey(first), 64)
+	assert.NotContains(t, hashApiKey(first), first[len(apiKeyPrefix):])
+}
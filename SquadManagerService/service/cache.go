@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+// CacheConfiguration sizes the cache of squad listings. Entries are dropped as soon as their tenant changes anything,
+// and after TTL at the latest, which bounds how stale a listing can get when other instances share the database.
+// MaxAge is how long callers may keep a listing themselves; when zero they must check back every time.
+type CacheConfiguration struct {
+	Enabled    bool
+	TTL        time.Duration
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+func (config CacheConfiguration) withDefaults() CacheConfiguration {
+	if config.TTL <= 0 {
+		config.TTL = 30 * time.Second
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 1000
+	}
+	return config
+}
+
+func (config CacheConfiguration) cacheControl() string {
+	if config.MaxAge <= 0 {
+		return "private, no-cache"
+	}
+	return fmt.Sprintf("private, max-age=%d", int(config.MaxAge.Seconds()))
+}
+
+// CachedListing is a squad listing response as it was sent.
+type CachedListing struct {
+	Header http.Header
+	Body   []byte
+}
+
+// ListingCache keeps squad listings for each tenant. Its generation for a tenant moves on with every invalidation, so
+// that a listing loaded before a change cannot be stored after it; Put ignores listings of an older generation. An
+// implementation shared between instances can stand in for the in-process one.
+type ListingCache interface {
+	Get(tenant string, key string) (CachedListing, bool)
+	Generation(tenant string) uint64
+	Put(tenant string, key string, generation uint64, listing CachedListing)
+	Invalidate(tenant string)
+}
+
+type listingKey struct {
+	tenant string
+	key    string
+}
+
+type cachedEntry struct {
+	listing CachedListing
+	expires time.Time
+}
+
+type memoryListingCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mutex       sync.Mutex
+	generations map[string]uint64
+	entries     map[listingKey]cachedEntry
+}
+
+func newMemoryListingCache(config CacheConfiguration) *memoryListingCache {
+	config = config.withDefaults()
+	return &memoryListingCache{
+		ttl:         config.TTL,
+		maxEntries:  config.MaxEntries,
+		now:         time.Now,
+		generations: map[string]uint64{},
+		entries:     map[listingKey]cachedEntry{},
+	}
+}
+
+func (cache *memoryListingCache) Get(tenant string, key string) (CachedListing, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, ok := cache.entries[listingKey{tenant, key}]
+	if !ok || !cache.now().Before(entry.expires) {
+		return CachedListing{}, false
+	}
+	return entry.listing, true
+}
+
+func (cache *memoryListingCache) Generation(tenant string) uint64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.generations[tenant]
+}
+
+func (cache *memoryListingCache) Put(tenant string, key string, generation uint64, listing CachedListing) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if generation != cache.generations[tenant] {
+		return
+	}
+	if len(cache.entries) >= cache.maxEntries {
+		cache.evict()
+	}
+	cache.entries[listingKey{tenant, key}] = cachedEntry{listing, cache.now().Add(cache.ttl)}
+}
+
+// evict makes room for one more entry, dropping every expired entry or, when none has expired, an arbitrary one.
+func (cache *memoryListingCache) evict() {
+	now := cache.now()
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expires) {
+			delete(cache.entries, key)
+		}
+	}
+	for key := range cache.entries {
+		if len(cache.entries) < cache.maxEntries {
+			return
+		}
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *memoryListingCache) Invalidate(tenant string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.generations[tenant]++
+	for key := range cache.entries {
+		if key.tenant == tenant {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// cacheInvalidator drops a tenant's cached listings whenever the repository reports a change to its squads.
+type cacheInvalidator struct {
+	cache ListingCache
+}
+
+func (invalidator cacheInvalidator) Publish(event api.Event) {
+	invalidator.cache.Invalidate(event.Tenant)
+}
+
+// cachedHeaders are the headers of a listing that belong to it rather than to the request that loaded it.
+var cachedHeaders = []string{"Content-Type", "Link"}
+
+// CachedHandler serves successful responses of the wrapped handler out of the listing cache, keyed by the tenant,
+// path and query of the request. Routes go through authorization before reaching it, so a hit is never shown to a
+// caller who could not have loaded it.
+type CachedHandler struct {
+	Handler ContextualHandler
+}
+
+func (handler CachedHandler) With(service *Context) httprouter.Handle {
+	next := handler.Handler.With(service)
+	cache := service.Listings
+	if cache == nil {
+		return next
+	}
+	cacheControl := service.Cache.cacheControl()
+
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		tenant := TenantFrom(request.Context())
+		key := request.URL.Path + "?" + request.URL.Query().Encode()
+
+		if listing, ok := cache.Get(tenant, key); ok {
+			service.Metrics.observeCacheLookup(true)
+			for _, name := range cachedHeaders {
+				if values, ok := listing.Header[name]; ok {
+					writer.Header()[name] = values
+				}
+			}
+			writer.Header().Set("Cache-Control", cacheControl)
+			writer.Header().Set("X-Cache", "HIT")
+			writer.WriteHeader(http.StatusOK)
+			writer.Write(listing.Body)
+			return
+		}
+
+		service.Metrics.observeCacheLookup(false)
+		generation := cache.Generation(tenant)
+		recorder := &listingRecorder{ResponseWriter: writer, cacheControl: cacheControl}
+		next(recorder, request, params)
+		if recorder.statusCode != http.StatusOK {
+			return
+		}
+		header := http.Header{}
+		for _, name := range cachedHeaders {
+			if values, ok := writer.Header()[name]; ok {
+				header[name] = values
+			}
+		}
+		cache.Put(tenant, key, generation, CachedListing{header, recorder.body.Bytes()})
+	}
+}
+
+// listingRecorder keeps a copy of the response it passes on, marking successful ones as cacheable.
+type listingRecorder struct {
+	http.ResponseWriter
+	cacheControl string
+	statusCode   int
+	body         bytes.Buffer
+}
+
+func (recorder *listingRecorder) WriteHeader(code int) {
+	recorder.statusCode = code
+	if code == http.StatusOK {
+		recorder.Header().Set("Cache-Control", recorder.cacheControl)
+		recorder.Header().Set("X-Cache", "MISS")
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *listingRecorder) Write(body []byte) (int, error) {
+	if recorder.statusCode == 0 {
+		recorder.WriteHeader(http.StatusOK)
+	}
+	recorder.body.Write(body)
+	return recorder.ResponseWriter.Write(body)
+}
+
+// forgetTenant drops the cached listings of the tenant named in the route once the wrapped handler has run, as
+// deleting a tenant drops its squads without reporting each change.
+func (context *Context) forgetTenant(handle httprouter.Handle) httprouter.Handle {
+	if context.Listings == nil {
+		return handle
+	}
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		handle(writer, request, params)
+		context.Listings.Invalidate(params.ByName("tenant"))
+	}
+}
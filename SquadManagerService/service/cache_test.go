@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryListingCacheWillForgetATenantsListingsOnceInvalidated(t *testing.T) {
+	cache := newMemoryListingCache(CacheConfiguration{})
+	listing := CachedListing{Body: []byte("[]")}
+	cache.Put("acme", "/squad?", cache.Generation("acme"), listing)
+	cache.Put("globex", "/squad?", cache.Generation("globex"), listing)
+
+	cacheInvalidator{cache}.Publish(api.Event{Tenant: "acme"})
+
+	_, found := cache.Get("acme", "/squad?")
+	assert.False(t, found)
+	kept, found := cache.Get("globex", "/squad?")
+	assert.True(t, found)
+	assert.Equal(t, listing, kept)
+}
+
+func TestMemoryListingCacheWillNotStoreListingsLoadedBeforeAChange(t *testing.T) {
+	cache := newMemoryListingCache(CacheConfiguration{})
+	generation := cache.Generation("")
+
+	cache.Invalidate("")
+	cache.Put("", "/squad?", generation, CachedListing{Body: []byte("[]")})
+
+	_, found := cache.Get("", "/squad?")
+	assert.False(t, found)
+}
+
+func TestMemoryListingCacheWillExpireAndEvictListings(t *testing.T) {
+	now := time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)
+	cache := newMemoryListingCache(CacheConfiguration{TTL: time.Minute, MaxEntries: 2})
+	cache.now = func() time.Time { return now }
+
+	for index := 0; index < 3; index++ {
+		cache.Put("", fmt.Sprintf("/squad?limit=%d", index+1), 0, CachedListing{})
+	}
+	assert.Len(t, cache.entries, 2)
+	_, found := cache.Get("", "/squad?limit=3")
+	assert.True(t, found)
+
+	now = now.Add(time.Minute)
+	_, found = cache.Get("", "/squad?limit=3")
+	assert.False(t, found)
+}
+
+func TestCachedHandlerWillServeRepeatedListingsFromTheCache(t *testing.T) {
+	service := &Context{
+		Metrics:  newMetrics(),
+		Cache:    CacheConfiguration{MaxAge: time.Minute},
+		Listings: newMemoryListingCache(CacheConfiguration{}),
+	}
+	loads := 0
+	handle := CachedHandler{ThinHandler(func(request *http.Request, _ httprouter.Params) (ResponseEntity, error) {
+		loads++
+		return ResponseEntity{headed{[]string{"squad"}, http.Header{"Link": {`</squad?cursor=c>; rel="next"`}}}, http.StatusOK}, nil
+	})}.With(service)
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handle(recorder, httptest.NewRequest("GET", path, nil), nil)
+		return recorder
+	}
+
+	first := serve("/squad?end=2017-09-01T00:00:00Z&begin=2017-08-01T00:00:00Z")
+	second := serve("/squad?begin=2017-08-01T00:00:00Z&end=2017-09-01T00:00:00Z")
+
+	assert.Equal(t, 1, loads)
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "private, max-age=60", second.Header().Get("Cache-Control"))
+	assert.Equal(t, first.Header().Get("Link"), second.Header().Get("Link"))
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+
+	serve("/squad?begin=2017-07-01T00:00:00Z")
+	assert.Equal(t, 2, loads)
+
+	exposed := &bytes.Buffer{}
+	service.Metrics.Expose(exposed, nil)
+	assert.Contains(t, exposed.String(), `squadmanager_listing_cache_lookups_total{result="hit"} 1`)
+	assert.Contains(t, exposed.String(), `squadmanager_listing_cache_lookups_total{result="miss"} 2`)
+}
+
+func TestCachedHandlerWillNotKeepFailedListings(t *testing.T) {
+	service := &Context{
+		Logger:   newDefaultLogger(LoggingConfiguration{Level: "error"}),
+		Listings: newMemoryListingCache(CacheConfiguration{}),
+	}
+	loads := 0
+	handle := CachedHandler{ThinHandler(func(request *http.Request, _ httprouter.Params) (ResponseEntity, error) {
+		loads++
+		return ResponseEntity{}, &StorageUnavailableError{errors.New("no reachable servers")}
+	})}.With(service)
+
+	for index := 0; index < 2; index++ {
+		recorder := httptest.NewRecorder()
+		handle(recorder, httptest.NewRequest("GET", "/squad", nil), nil)
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Cache-Control"))
+	}
+	assert.Equal(t, 2, loads)
+}
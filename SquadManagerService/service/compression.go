@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// CompressionConfiguration chooses which responses are compressed. Bodies smaller than MinimumBytes are sent as they
+// are, as compressing them costs more than it saves.
+type CompressionConfiguration struct {
+	Enabled      bool
+	MinimumBytes int
+}
+
+func (config CompressionConfiguration) withDefaults() CompressionConfiguration {
+	if config.MinimumBytes <= 0 {
+		config.MinimumBytes = 1024
+	}
+	return config
+}
+
+// encoders are the content codings the service can send, in order of preference when a caller accepts several
+// equally.
+var encoders = []struct {
+	name      string
+	newWriter func(io.Writer) compressor
+}{
+	{"br", func(writer io.Writer) compressor { return brotli.NewWriterLevel(writer, 5) }},
+	{"gzip", newGzipWriter},
+}
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var gzipWriters sync.Pool
+
+func newGzipWriter(writer io.Writer) compressor {
+	if pooled, ok := gzipWriters.Get().(*gzip.Writer); ok {
+		pooled.Reset(writer)
+		return pooledGzipWriter{pooled}
+	}
+	return pooledGzipWriter{gzip.NewWriter(writer)}
+}
+
+// pooledGzipWriter returns its writer to the pool once closed.
+type pooledGzipWriter struct {
+	*gzip.Writer
+}
+
+func (writer pooledGzipWriter) Close() error {
+	err := writer.Writer.Close()
+	gzipWriters.Put(writer.Writer)
+	return err
+}
+
+// negotiateEncoding picks the content coding to answer with out of those the Accept-Encoding header allows, or ""
+// when the response should go out as it is.
+func negotiateEncoding(acceptEncoding string) string {
+	weights := map[string]float64{}
+	for _, element := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(element, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				parsed, err := strconv.ParseFloat(strings.TrimPrefix(parameter, "q="), 64)
+				if err != nil {
+					parsed = 0
+				}
+				weight = parsed
+			}
+		}
+		weights[name] = weight
+	}
+
+	chosen, best := "", 0.0
+	for _, encoder := range encoders {
+		weight, named := weights[encoder.name]
+		if !named {
+			weight, named = weights["*"]
+		}
+		if named && weight > best {
+			chosen, best = encoder.name, weight
+		}
+	}
+	return chosen
+}
+
+// Compress encodes response bodies with the best content coding the caller accepts. Responses are held back until
+// they reach the minimum size, so that small ones can still go out uncompressed; a flush sends what has been written
+// so far compressed, keeping streams flowing.
+func Compress(config CompressionConfiguration, next http.HandlerFunc) http.HandlerFunc {
+	if !config.Enabled {
+		return next
+	}
+	config = config.withDefaults()
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(request.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(writer, request)
+			return
+		}
+
+		compressing := &compressingResponseWriter{ResponseWriter: writer, encoding: encoding, minimumBytes: config.MinimumBytes}
+		defer compressing.close()
+		next(compressing, request)
+	}
+}
+
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	encoding     string
+	minimumBytes int
+
+	statusCode int
+	buffered   bytes.Buffer
+	// decided is set once the response has gone out, compressed by compressor or, when that is nil, as it is.
+	decided    bool
+	compressor compressor
+}
+
+func (writer *compressingResponseWriter) WriteHeader(code int) {
+	if writer.statusCode == 0 {
+		writer.statusCode = code
+	}
+}
+
+func (writer *compressingResponseWriter) Write(body []byte) (int, error) {
+	if writer.statusCode == 0 {
+		writer.statusCode = http.StatusOK
+	}
+	if writer.decided {
+		if writer.compressor != nil {
+			return writer.compressor.Write(body)
+		}
+		return writer.ResponseWriter.Write(body)
+	}
+
+	writer.buffered.Write(body)
+	if writer.buffered.Len() >= writer.minimumBytes {
+		if err := writer.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(body), nil
+}
+
+func (writer *compressingResponseWriter) Flush() {
+	if !writer.decided && writer.statusCode != 0 {
+		writer.decide(writer.buffered.Len() > 0)
+	}
+	if writer.compressor != nil {
+		writer.compressor.Flush()
+	}
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// decide sends the headers and whatever has been held back, compressed when asked to and the response allows it.
+func (writer *compressingResponseWriter) decide(compress bool) error {
+	writer.decided = true
+	header := writer.Header()
+	if compress && header.Get("Content-Encoding") == "" && bodyAllowed(writer.statusCode) {
+		header.Set("Content-Encoding", writer.encoding)
+		header.Del("Content-Length")
+		for _, encoder := range encoders {
+			if encoder.name == writer.encoding {
+				writer.compressor = encoder.newWriter(writer.ResponseWriter)
+			}
+		}
+	}
+	writer.ResponseWriter.WriteHeader(writer.statusCode)
+
+	held := writer.buffered.Bytes()
+	writer.buffered = bytes.Buffer{}
+	if writer.compressor != nil {
+		_, err := writer.compressor.Write(held)
+		return err
+	}
+	_, err := writer.ResponseWriter.Write(held)
+	return err
+}
+
+func (writer *compressingResponseWriter) close() {
+	if !writer.decided {
+		if writer.statusCode == 0 {
+			return
+		}
+		writer.decide(false)
+	}
+	if writer.compressor != nil {
+		writer.compressor.Close()
+	}
+}
+
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncodingWillPickTheMostWantedCodingWeSupport(t *testing.T) {
+	cases := map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  "gzip",
+		"gzip, deflate, br":     "br",
+		"br;q=0.5, gzip":        "gzip",
+		"GZIP;q=0.8":            "gzip",
+		"*":                     "br",
+		"*;q=0.5, br;q=0":       "gzip",
+		"gzip;q=0, br;q=0":      "",
+		"gzip;q=high, deflate":  "",
+		"deflate, compress;q=1": "",
+	}
+
+	for acceptEncoding, expected := range cases {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func compressedHandler(body string, code int) http.HandlerFunc {
+	return Compress(CompressionConfiguration{Enabled: true, MinimumBytes: 64}, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(code)
+		for _, line := range strings.SplitAfter(body, "\n") {
+			writer.Write([]byte(line))
+		}
+	})
+}
+
+func TestCompressWillEncodeLargeBodiesForCallersThatAcceptIt(t *testing.T) {
+	body := strings.Repeat(`{"Email":"dale@fake.com"}`+"\n", 20)
+	tester := testutil.New(t, compressedHandler(body, http.StatusOK))
+
+	for _, encoding := range []string{"gzip", "br"} {
+		response := tester.WithHeader("Accept-Encoding", encoding).DoRequest("GET", "/squad", nil).
+			CheckStatus(http.StatusOK)
+
+		assert.Equal(t, encoding, response.Recorder.Header().Get("Content-Encoding"))
+		assert.Contains(t, response.Recorder.Header()["Vary"], "Accept-Encoding")
+		assert.Equal(t, body, string(response.Body()))
+	}
+}
+
+func TestCompressWillLeaveSmallBodiesAndUnwillingCallersAlone(t *testing.T) {
+	body := `{"Email":"dale@fake.com"}`
+	small := testutil.New(t, compressedHandler(body, http.StatusNotFound)).WithHeader("Accept-Encoding", "gzip")
+	large := testutil.New(t, compressedHandler(strings.Repeat(body, 10), http.StatusOK))
+
+	for _, response := range []testutil.Response{
+		small.DoRequest("GET", "/squad", nil).CheckStatus(http.StatusNotFound),
+		large.DoRequest("GET", "/squad", nil).CheckStatus(http.StatusOK),
+	} {
+		assert.Empty(t, response.Recorder.Header().Get("Content-Encoding"))
+		assert.Contains(t, response.Recorder.Header()["Vary"], "Accept-Encoding")
+		assert.Equal(t, response.Recorder.Body.String(), string(response.Body()))
+	}
+	assert.Equal(t, body, small.DoRequest("GET", "/squad", nil).Recorder.Body.String())
+}
+
+func TestCompressWillSendWhatIsFlushedRightAway(t *testing.T) {
+	flushed := make(chan string, 1)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/events", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	Compress(CompressionConfiguration{Enabled: true}, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(": connected\n\n"))
+		writer.(http.Flusher).Flush()
+		flushed <- recorder.Header().Get("Content-Encoding")
+	})(recorder, request)
+
+	assert.Equal(t, "gzip", <-flushed)
+	assert.True(t, recorder.Flushed)
+	response := testutil.Response{Tester: testutil.New(t, nil), Recorder: recorder}
+	assert.Equal(t, ": connected\n\n", string(response.Body()))
+}
+
+func TestCompressWillNotTouchBodilessResponses(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("DELETE", "/squad/1", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	Compress(CompressionConfiguration{Enabled: true}, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})(recorder, request)
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Zero(t, recorder.Body.Len())
+}
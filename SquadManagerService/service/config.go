@@ -0,0 +1,430 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+const environmentPrefix = "SQUADMANAGER_"
+
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		ListenAddress:   ":8080",
+		ShutdownTimeout: 30 * time.Second,
+		RequestTimeout:  10 * time.Second,
+		Host:            "localhost",
+		DatabaseName:    "SquadManager",
+		DbTimeout:       time.Second,
+		Logging:         LoggingConfiguration{}.withDefaults(),
+		Auth:            AuthConfiguration{}.withDefaults(),
+		Cors:            CorsConfiguration{}.withDefaults(),
+		Limits:          LimitsConfiguration{}.withDefaults(),
+		Health:          HealthConfiguration{}.withDefaults(),
+		Webhooks:        WebhookConfiguration{}.withDefaults(),
+		Events:          EventConfiguration{}.withDefaults(),
+		Cache:           CacheConfiguration{Enabled: true}.withDefaults(),
+		Compression:     CompressionConfiguration{Enabled: true}.withDefaults(),
+	}
+}
+
+// setting describes one configuration value by its dotted key, as used in configuration files. The matching flag
+// and environment variable names are derived from the key.
+type setting struct {
+	key         string
+	description string
+	get         func(config *Configuration) interface{}
+	set         func(config *Configuration, value string) error
+}
+
+func stringSetting(key string, description string, field func(config *Configuration) *string) setting {
+	return setting{key, description,
+		func(config *Configuration) interface{} { return *field(config) },
+		func(config *Configuration, value string) error {
+			*field(config) = value
+			return nil
+		},
+	}
+}
+
+// secretSetting is a string setting that is never written back out in full.
+func secretSetting(key string, description string, field func(config *Configuration) *string) setting {
+	setting := stringSetting(key, description, field)
+	setting.get = func(config *Configuration) interface{} {
+		if *field(config) == "" {
+			return ""
+		}
+		return "(redacted)"
+	}
+	return setting
+}
+
+func boolSetting(key string, description string, field func(config *Configuration) *bool) setting {
+	return setting{key, description,
+		func(config *Configuration) interface{} { return *field(config) },
+		func(config *Configuration, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, not %q", key, value)
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+func intSetting(key string, description string, field func(config *Configuration) *int) setting {
+	return setting{key, description,
+		func(config *Configuration) interface{} { return *field(config) },
+		func(config *Configuration, value string) error {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, not %q", key, value)
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+// listSetting holds a list, given as a comma-separated string in flags and the environment or as a list in files.
+func listSetting(key string, description string, field func(config *Configuration) *[]string) setting {
+	return setting{key, description,
+		func(config *Configuration) interface{} { return append([]string{}, *field(config)...) },
+		func(config *Configuration, value string) error {
+			var values []string
+			for _, element := range strings.Split(value, ",") {
+				if element = strings.TrimSpace(element); element != "" {
+					values = append(values, element)
+				}
+			}
+			*field(config) = values
+			return nil
+		},
+	}
+}
+
+func durationSetting(key string, description string, field func(config *Configuration) *time.Duration) setting {
+	return setting{key, description,
+		func(config *Configuration) interface{} { return field(config).String() },
+		func(config *Configuration, value string) error {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration with a unit such as 500ms or 2s, not %q", key, value)
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("listen", "address for the HTTP server to listen on",
+		func(config *Configuration) *string { return &config.ListenAddress }),
+	durationSetting("shutdownTimeout", "time allowed for in-flight requests to finish when shutting down",
+		func(config *Configuration) *time.Duration { return &config.ShutdownTimeout }),
+	durationSetting("requestTimeout", "time allowed for the storage work of each request",
+		func(config *Configuration) *time.Duration { return &config.RequestTimeout }),
+	stringSetting("database.host", "MongoDB host to connect to",
+		func(config *Configuration) *string { return &config.Host }),
+	stringSetting("database.name", "MongoDB database holding the squads",
+		func(config *Configuration) *string { return &config.DatabaseName }),
+	durationSetting("database.timeout", "timeout for connecting to MongoDB",
+		func(config *Configuration) *time.Duration { return &config.DbTimeout }),
+	boolSetting("database.skipIndexes", "leave creating indexes to the operator instead of ensuring them on connecting",
+		func(config *Configuration) *bool { return &config.SkipIndexes }),
+	stringSetting("logging.format", "log line format: json or logfmt",
+		func(config *Configuration) *string { return &config.Logging.Format }),
+	stringSetting("logging.level", "lowest level logged: debug, info, warn or error",
+		func(config *Configuration) *string { return &config.Logging.Level }),
+	boolSetting("auth.enabled", "require an API key on every route except health checks and metrics; on whenever "+
+		"auth.adminKey or auth.jwt.jwks is set",
+		func(config *Configuration) *bool { return &config.Auth.Enabled }),
+	secretSetting("auth.adminKey", "bootstrap key with admin access, for creating the first API keys",
+		func(config *Configuration) *string { return &config.Auth.AdminKey }),
+	stringSetting("auth.jwt.jwks", "URL or file of the identity provider's JWKS; enables bearer tokens when set",
+		func(config *Configuration) *string { return &config.Auth.Jwt.Jwks }),
+	stringSetting("auth.jwt.issuer", "issuer that tokens must name",
+		func(config *Configuration) *string { return &config.Auth.Jwt.Issuer }),
+	stringSetting("auth.jwt.audience", "audience that tokens must include",
+		func(config *Configuration) *string { return &config.Auth.Jwt.Audience }),
+	stringSetting("auth.jwt.nameClaim", "token claim identifying the caller",
+		func(config *Configuration) *string { return &config.Auth.Jwt.NameClaim }),
+	stringSetting("auth.jwt.rolesClaim", "token claim listing the caller's roles",
+		func(config *Configuration) *string { return &config.Auth.Jwt.RolesClaim }),
+	stringSetting("auth.jwt.squadsClaim", "token claim listing the squads a squad lead leads",
+		func(config *Configuration) *string { return &config.Auth.Jwt.SquadsClaim }),
+	stringSetting("auth.jwt.tenantClaim", "token claim naming the caller's tenant",
+		func(config *Configuration) *string { return &config.Auth.Jwt.TenantClaim }),
+	stringSetting("tenancy.mode", "how requests name their tenant: path, subdomain or claim; empty for a single tenant",
+		func(config *Configuration) *string { return &config.Tenancy.Mode }),
+	stringSetting("tenancy.domain", "domain under which each tenant has a subdomain",
+		func(config *Configuration) *string { return &config.Tenancy.Domain }),
+	durationSetting("auth.jwt.cacheDuration", "how long to cache the JWKS before loading it again",
+		func(config *Configuration) *time.Duration { return &config.Auth.Jwt.CacheDuration }),
+	durationSetting("auth.jwt.leeway", "clock skew allowed when checking token expiry",
+		func(config *Configuration) *time.Duration { return &config.Auth.Jwt.Leeway }),
+	durationSetting("health.readinessTimeout", "time allowed for each dependency to answer a readiness check",
+		func(config *Configuration) *time.Duration { return &config.Health.ReadinessTimeout }),
+	boolSetting("health.failFast", "refuse to start unless every dependency is reachable",
+		func(config *Configuration) *bool { return &config.Health.FailFast }),
+	listSetting("cors.allowedOrigins", "origins whose pages may call the API, or * for any; CORS is off when empty",
+		func(config *Configuration) *[]string { return &config.Cors.AllowedOrigins }),
+	listSetting("cors.allowedMethods", "methods cross-origin pages may use",
+		func(config *Configuration) *[]string { return &config.Cors.AllowedMethods }),
+	listSetting("cors.allowedHeaders", "request headers cross-origin pages may send",
+		func(config *Configuration) *[]string { return &config.Cors.AllowedHeaders }),
+	listSetting("cors.exposedHeaders", "response headers cross-origin pages may read",
+		func(config *Configuration) *[]string { return &config.Cors.ExposedHeaders }),
+	boolSetting("cors.allowCredentials", "let cross-origin pages send cookies and authorization",
+		func(config *Configuration) *bool { return &config.Cors.AllowCredentials }),
+	durationSetting("cors.maxAge", "how long browsers may cache a preflight response",
+		func(config *Configuration) *time.Duration { return &config.Cors.MaxAge }),
+	intSetting("limits.requestsPerMinute", "requests each caller, or each address when unauthenticated, may make per minute; unlimited when zero",
+		func(config *Configuration) *int { return &config.Limits.RequestsPerMinute }),
+	intSetting("limits.burst", "requests each client may make at once before the rate limit applies",
+		func(config *Configuration) *int { return &config.Limits.Burst }),
+	intSetting("limits.addressRequestsPerMinute", "requests each address may make per minute to authenticated routes before its callers are identified; unlimited when zero",
+		func(config *Configuration) *int { return &config.Limits.AddressRequestsPerMinute }),
+	intSetting("limits.addressBurst", "requests each address may make at once before the address rate limit applies",
+		func(config *Configuration) *int { return &config.Limits.AddressBurst }),
+	intSetting("limits.maxBodyBytes", "largest request body accepted, in bytes",
+		func(config *Configuration) *int { return &config.Limits.MaxBodyBytes }),
+	intSetting("webhooks.maxAttempts", "delivery attempts per webhook event",
+		func(config *Configuration) *int { return &config.Webhooks.MaxAttempts }),
+	durationSetting("webhooks.initialBackoff", "delay before the first webhook retry",
+		func(config *Configuration) *time.Duration { return &config.Webhooks.InitialBackoff }),
+	durationSetting("webhooks.maxBackoff", "longest delay between webhook retries",
+		func(config *Configuration) *time.Duration { return &config.Webhooks.MaxBackoff }),
+	durationSetting("webhooks.timeout", "timeout for each webhook delivery",
+		func(config *Configuration) *time.Duration { return &config.Webhooks.Timeout }),
+	intSetting("events.historySize", "events kept for resuming event streams",
+		func(config *Configuration) *int { return &config.Events.HistorySize }),
+	intSetting("events.subscriberBuffer", "events buffered for each event stream",
+		func(config *Configuration) *int { return &config.Events.SubscriberBuffer }),
+	durationSetting("events.keepAliveInterval", "interval between event stream keep-alives",
+		func(config *Configuration) *time.Duration { return &config.Events.KeepAliveInterval }),
+	boolSetting("cache.enabled", "keep squad listings in memory until their tenant changes anything",
+		func(config *Configuration) *bool { return &config.Cache.Enabled }),
+	durationSetting("cache.ttl", "longest time a squad listing is kept, for changes made through other instances",
+		func(config *Configuration) *time.Duration { return &config.Cache.TTL }),
+	intSetting("cache.maxEntries", "most squad listings kept at once",
+		func(config *Configuration) *int { return &config.Cache.MaxEntries }),
+	durationSetting("cache.maxAge", "how long callers may keep a squad listing; they check back every time when zero",
+		func(config *Configuration) *time.Duration { return &config.Cache.MaxAge }),
+	boolSetting("compression.enabled", "compress responses with gzip or brotli for callers that accept them",
+		func(config *Configuration) *bool { return &config.Compression.Enabled }),
+	intSetting("compression.minimumBytes", "smallest response body compressed, in bytes",
+		func(config *Configuration) *int { return &config.Compression.MinimumBytes }),
+}
+
+func (setting setting) flagName() string {
+	return strings.Replace(splitWords(setting.key, "-"), ".", "-", -1)
+}
+
+func (setting setting) environmentName() string {
+	return environmentPrefix + strings.ToUpper(strings.Replace(splitWords(setting.key, "_"), ".", "_", -1))
+}
+
+func splitWords(key string, separator string) string {
+	var words []rune
+	for _, character := range key {
+		if unicode.IsUpper(character) {
+			words = append(words, []rune(separator)...)
+		}
+		words = append(words, unicode.ToLower(character))
+	}
+	return string(words)
+}
+
+// LoadConfiguration registers a flag for every setting on the given flag set, parses the arguments and builds the
+// configuration. Values from the configuration file (--config, or SQUADMANAGER_CONFIG) override the defaults,
+// environment variables override the file and flags override everything else.
+func LoadConfiguration(flags *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Configuration, error) {
+	configFile := flags.String("config", "", "YAML or JSON configuration file (env "+environmentPrefix+"CONFIG)")
+	flagValues := map[string]*string{}
+	for _, setting := range settings {
+		flagValues[setting.key] = flags.String(
+			setting.flagName(), "", setting.description+" (env "+setting.environmentName()+")",
+		)
+	}
+	if err := flags.Parse(args); err != nil {
+		return Configuration{}, err
+	}
+
+	config := DefaultConfiguration()
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(environmentPrefix + "CONFIG")
+	}
+	if *configFile != "" {
+		if err := applyConfigurationFile(&config, *configFile); err != nil {
+			return Configuration{}, err
+		}
+	}
+
+	for _, setting := range settings {
+		if value, ok := lookupEnv(setting.environmentName()); ok {
+			if err := setting.set(&config, value); err != nil {
+				return Configuration{}, err
+			}
+		}
+	}
+
+	setFlags := map[string]bool{}
+	flags.Visit(func(visited *flag.Flag) { setFlags[visited.Name] = true })
+	for _, setting := range settings {
+		if setFlags[setting.flagName()] {
+			if err := setting.set(&config, *flagValues[setting.key]); err != nil {
+				return Configuration{}, err
+			}
+		}
+	}
+
+	config.Auth = config.Auth.enabledByCredentials()
+	return config, config.Validate()
+}
+
+func applyConfigurationFile(config *Configuration, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is a subset of YAML, so one parser serves both formats.
+	var document map[interface{}]interface{}
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return fmt.Errorf("could not parse %s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flattenConfiguration("", document, values); err != nil {
+		return fmt.Errorf("could not parse %s: %v", path, err)
+	}
+
+	for _, setting := range settings {
+		if value, ok := values[setting.key]; ok {
+			if err := setting.set(config, value); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			delete(values, setting.key)
+		}
+	}
+
+	if len(values) != 0 {
+		var unknown []string
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf("%s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func flattenConfiguration(prefix string, document map[interface{}]interface{}, values map[string]string) error {
+	for key, value := range document {
+		name := prefix + fmt.Sprint(key)
+		switch value := value.(type) {
+		case map[interface{}]interface{}:
+			if err := flattenConfiguration(name+".", value, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			elements := make([]string, len(value))
+			for index, element := range value {
+				switch element.(type) {
+				case map[interface{}]interface{}, []interface{}:
+					return fmt.Errorf("setting %s must be a list of plain values", name)
+				}
+				elements[index] = fmt.Sprint(element)
+			}
+			values[name] = strings.Join(elements, ",")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+func (config Configuration) Validate() error {
+	var problems []string
+	if config.ListenAddress == "" {
+		problems = append(problems, "listen must not be empty")
+	}
+	if config.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdownTimeout must be positive")
+	}
+	if config.RequestTimeout <= 0 {
+		problems = append(problems, "requestTimeout must be positive")
+	}
+	if config.Host == "" {
+		problems = append(problems, "database.host must not be empty")
+	}
+	if config.DatabaseName == "" {
+		problems = append(problems, "database.name must not be empty")
+	}
+	if config.DbTimeout <= 0 {
+		problems = append(problems, "database.timeout must be positive")
+	}
+	if config.Auth.Jwt.Jwks != "" && (config.Auth.Jwt.Issuer == "" || config.Auth.Jwt.Audience == "") {
+		problems = append(problems, "auth.jwt.issuer and auth.jwt.audience must be set to accept tokens")
+	}
+	if config.Cors.AllowCredentials && containsString(config.Cors.AllowedOrigins, "*") {
+		problems = append(problems, "cors.allowCredentials cannot be combined with the * origin")
+	}
+	if err := config.Tenancy.validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if config.Tenancy.Mode == "claim" && (!config.Auth.Enabled || config.Auth.Jwt.Jwks == "") {
+		problems = append(problems, "claim tenancy needs auth.enabled and auth.jwt.jwks")
+	}
+	if _, err := NewLogger(ioutil.Discard, config.Logging); err != nil {
+		problems = append(problems, "logging: "+err.Error())
+	}
+	for _, setting := range settings {
+		switch value := setting.get(&config).(type) {
+		case int:
+			if value < 0 {
+				problems = append(problems, setting.key+" must not be negative")
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// WriteConfiguration writes the configuration in the YAML configuration file format.
+func WriteConfiguration(writer io.Writer, config Configuration) error {
+	document := map[string]interface{}{}
+	for _, setting := range settings {
+		section := document
+		path := strings.Split(setting.key, ".")
+		for _, name := range path[:len(path)-1] {
+			if _, ok := section[name]; !ok {
+				section[name] = map[string]interface{}{}
+			}
+			section = section[name].(map[string]interface{})
+		}
+		section[path[len(path)-1]] = setting.get(&config)
+	}
+
+	contents, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(contents)
+	return err
+}
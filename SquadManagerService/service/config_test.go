@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func environment(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func loadTestConfiguration(t *testing.T, args []string, env map[string]string) (Configuration, error) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return LoadConfiguration(flags, args, environment(env))
+}
+
+func writeConfigFile(t *testing.T, name string, contents string) string {
+	directory, err := ioutil.TempDir("", "squadmanager-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigurationWithNothingSetWillUseDefaults(t *testing.T) {
+	config, err := loadTestConfiguration(t, nil, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfiguration(), config)
+	assert.Equal(t, time.Second, config.DbTimeout)
+}
+
+func TestLoadConfigurationWillPreferFlagsOverEnvironmentOverFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+listen: ":9000"
+database:
+  host: filehost
+  name: FileDB
+  timeout: 3s
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := loadTestConfiguration(t,
+		[]string{"--config", path, "--database-host", "flaghost"},
+		map[string]string{"SQUADMANAGER_DATABASE_HOST": "envhost", "SQUADMANAGER_DATABASE_NAME": "EnvDB"},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ":9000", config.ListenAddress)
+	assert.Equal(t, "flaghost", config.Host)
+	assert.Equal(t, "EnvDB", config.DatabaseName)
+	assert.Equal(t, 3*time.Second, config.DbTimeout)
+}
+
+func TestLoadConfigurationWillReadJsonFileNamedInEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"webhooks": {"maxAttempts": 7, "initialBackoff": "250ms"}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := loadTestConfiguration(t, nil, map[string]string{"SQUADMANAGER_CONFIG": path})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, config.Webhooks.MaxAttempts)
+	assert.Equal(t, 250*time.Millisecond, config.Webhooks.InitialBackoff)
+}
+
+func TestLoadConfigurationWillRejectDurationsWithoutUnits(t *testing.T) {
+	_, err := loadTestConfiguration(t, []string{"--database-timeout", "1000"}, nil)
+
+	assert.Error(t, err)
+}
+
+func TestLoadConfigurationWillRejectUnknownFileSettings(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "database:\n  hots: typo\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := loadTestConfiguration(t, []string{"--config", path}, nil)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "database.hots")
+	}
+}
+
+func TestLoadConfigurationWillRejectInvalidValues(t *testing.T) {
+	_, err := loadTestConfiguration(t, nil, map[string]string{
+		"SQUADMANAGER_DATABASE_NAME":         "",
+		"SQUADMANAGER_WEBHOOKS_MAX_ATTEMPTS": "-1",
+	})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "database.name")
+		assert.Contains(t, err.Error(), "webhooks.maxAttempts")
+	}
+}
+
+func TestLoadConfigurationWillReadListsFromFilesAndCommaSeparatedValues(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+cors:
+  allowedOrigins:
+    - https://dashboard.example.com
+    - https://admin.example.com
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := loadTestConfiguration(t,
+		[]string{"--config", path, "--cors-allowed-methods", "GET, POST"}, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://dashboard.example.com", "https://admin.example.com"}, config.Cors.AllowedOrigins)
+	assert.Equal(t, []string{"GET", "POST"}, config.Cors.AllowedMethods)
+}
+
+func TestLoadConfigurationWillEnableAuthWhenCredentialsAreConfigured(t *testing.T) {
+	withAdminKey, err := loadTestConfiguration(t, []string{"--auth-admin-key", "s3cret"}, nil)
+	assert.NoError(t, err)
+	withJwks, err := loadTestConfiguration(t, []string{"--auth-enabled", "false", "--auth-jwt-jwks", "keys.json",
+		"--auth-jwt-issuer", "https://sso.example.com", "--auth-jwt-audience", "squadmanager"}, nil)
+	assert.NoError(t, err)
+
+	assert.True(t, withAdminKey.Auth.Enabled)
+	assert.True(t, withJwks.Auth.Enabled)
+}
+
+func TestWrittenConfigurationCanBeLoadedAgain(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Host = "mongo.internal"
+	config.Cors.AllowedOrigins = []string{"https://dashboard.example.com"}
+	config.Events.KeepAliveInterval = 30 * time.Second
+	written := &bytes.Buffer{}
+	assert.NoError(t, WriteConfiguration(written, config))
+	path := writeConfigFile(t, "config.yaml", written.String())
+	defer os.RemoveAll(filepath.Dir(path))
+
+	loaded, err := loadTestConfiguration(t, []string{"--config", path}, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, config, loaded)
+}
@@ -1,17 +1,74 @@
 package service
 
 import (
+	"os"
+	"time"
+
 	"github.com/julienschmidt/httprouter"
 )
 
 type Context struct {
-	RepositoryFactory *SquadRepositoryFactory
+	RepositoryFactory  *SquadRepositoryFactory
+	Events             *EventBus
+	Webhooks           *WebhookDispatcher
+	Metrics            *Metrics
+	Logger             *Logger
+	RequestTimeout     time.Duration
+	Auth               AuthConfiguration
+	Tokens             *TokenVerifier
+	Tenancy            TenancyConfiguration
+	Limits             LimitsConfiguration
+	RateLimiter        *RateLimiter
+	AddressRateLimiter *RateLimiter
+	Cache              CacheConfiguration
+	Listings           ListingCache
 }
 
 func newContext(config Configuration) (*Context, error) {
-	repositoryFactory := SquadRepositoryFactory{config, nil}
+	logger, err := NewLogger(os.Stderr, config.Logging)
+	if err != nil {
+		return nil, err
+	}
+	metrics := newMetrics()
+	repositoryFactory := SquadRepositoryFactory{Config: config, metrics: metrics, logger: logger}
+	webhooks := newWebhookDispatcher(config.Webhooks, logger, func(tenant string) (webhookStore, error) {
+		repository, err := repositoryFactory.TenantRepository(tenant)
+		if err != nil {
+			return nil, err
+		}
+		return repository, nil
+	})
+	events := newEventBus(config.Events)
+	events.Attach(webhooks)
+	repositoryFactory.publisher = events
+
+	var listings ListingCache
+	if config.Cache.Enabled {
+		listings = newMemoryListingCache(config.Cache)
+		events.Attach(cacheInvalidator{listings})
+	}
+
+	var tokens *TokenVerifier
+	if config.Auth.Jwt.Jwks != "" {
+		tokens = newTokenVerifier(config.Auth.Jwt)
+	}
 
-	squadService := Context{&repositoryFactory}
+	squadService := Context{
+		RepositoryFactory:  &repositoryFactory,
+		Events:             events,
+		Webhooks:           webhooks,
+		Metrics:            metrics,
+		Logger:             logger,
+		RequestTimeout:     config.RequestTimeout,
+		Auth:               config.Auth,
+		Tokens:             tokens,
+		Tenancy:            config.Tenancy,
+		Limits:             config.Limits.withDefaults(),
+		RateLimiter:        newRateLimiter(config.Limits),
+		AddressRateLimiter: newAddressRateLimiter(config.Limits),
+		Cache:              config.Cache.withDefaults(),
+		Listings:           listings,
+	}
 
 	return &squadService, nil
 }
@@ -25,5 +82,7 @@ func (context *Context) with(contextual ContextualHandler) httprouter.Handle {
 }
 
 func (context *Context) Close() {
+	context.Events.Close()
+	context.Webhooks.Close()
 	context.RepositoryFactory.Close()
 }
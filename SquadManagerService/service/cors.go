@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsConfiguration lets pages served from other origins, such as the dashboard, call the API from a browser. CORS is
+// off until at least one origin is allowed; "*" allows any origin.
+type CorsConfiguration struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func (config CorsConfiguration) withDefaults() CorsConfiguration {
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	if len(config.AllowedHeaders) == 0 {
+		config.AllowedHeaders = []string{"Authorization", "Content-Type", RequestIdHeader, "Last-Event-ID"}
+	}
+	if len(config.ExposedHeaders) == 0 {
+		config.ExposedHeaders = []string{RequestIdHeader}
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = 10 * time.Minute
+	}
+	return config
+}
+
+func (config CorsConfiguration) allowsOrigin(origin string) bool {
+	return containsString(config.AllowedOrigins, "*") || containsString(config.AllowedOrigins, origin)
+}
+
+func (config CorsConfiguration) allowsMethod(method string) bool {
+	return containsString(config.AllowedMethods, method)
+}
+
+func (config CorsConfiguration) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := false
+		for _, known := range config.AllowedHeaders {
+			if strings.EqualFold(header, known) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// Cors answers preflight requests itself, before they reach authentication, and marks the responses to other
+// requests from allowed origins as readable by them. Requests from any other origin pass through untouched, which
+// leaves the browser to refuse them.
+func Cors(config CorsConfiguration, next http.HandlerFunc) http.HandlerFunc {
+	if len(config.AllowedOrigins) == 0 {
+		return next
+	}
+	config = config.withDefaults()
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		origin := request.Header.Get("Origin")
+		writer.Header().Add("Vary", "Origin")
+		if origin == "" || !config.allowsOrigin(origin) {
+			next(writer, request)
+			return
+		}
+
+		if config.AllowCredentials || !containsString(config.AllowedOrigins, "*") {
+			writer.Header().Set("Access-Control-Allow-Origin", origin)
+		} else {
+			writer.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if config.AllowCredentials {
+			writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		requestedMethod := request.Header.Get("Access-Control-Request-Method")
+		if request.Method != "OPTIONS" || requestedMethod == "" {
+			writer.Header().Set("Access-Control-Expose-Headers", exposed)
+			next(writer, request)
+			return
+		}
+
+		if !config.allowsMethod(requestedMethod) || !config.allowsHeaders(request.Header.Get("Access-Control-Request-Headers")) {
+			respond(writer, request, ResponseEntity{code: http.StatusForbidden})
+			return
+		}
+		writer.Header().Add("Vary", "Access-Control-Request-Method")
+		writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		writer.Header().Set("Access-Control-Allow-Methods", methods)
+		writer.Header().Set("Access-Control-Allow-Headers", headers)
+		writer.Header().Set("Access-Control-Max-Age", maxAge)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
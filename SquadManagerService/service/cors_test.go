@@ -0,0 +1,121 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var dashboardCors = CorsConfiguration{AllowedOrigins: []string{"https://dashboard.example.com"}}
+
+func preflight(origin string, method string, headers string) *http.Request {
+	request := httptest.NewRequest("OPTIONS", "/squad", nil)
+	request.Header.Set("Origin", origin)
+	request.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		request.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return request
+}
+
+func TestCorsWillAnswerPreflightsFromAllowedOrigins(t *testing.T) {
+	called := false
+	handler := Cors(dashboardCors, func(http.ResponseWriter, *http.Request) { called = true })
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, preflight("https://dashboard.example.com", "PUT", "authorization, content-type"))
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "https://dashboard.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST, PUT, DELETE", recorder.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type, X-Request-ID, Last-Event-ID", recorder.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, recorder.Header()["Vary"], "Origin")
+}
+
+func TestCorsWillRefusePreflightsForMethodsOrHeadersNotAllowed(t *testing.T) {
+	handler := Cors(CorsConfiguration{AllowedOrigins: dashboardCors.AllowedOrigins, AllowedMethods: []string{"GET"}},
+		func(http.ResponseWriter, *http.Request) {})
+
+	for _, request := range []*http.Request{
+		preflight("https://dashboard.example.com", "DELETE", ""),
+		preflight("https://dashboard.example.com", "GET", "X-Debug"),
+	} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestCorsWillMarkSimpleRequestsFromAllowedOrigins(t *testing.T) {
+	config := dashboardCors
+	config.AllowCredentials = true
+	handler := Cors(config, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set("Origin", "https://dashboard.example.com")
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusTeapot, recorder.Code)
+	assert.Equal(t, "https://dashboard.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", recorder.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, RequestIdHeader, recorder.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestCorsWillLeaveOtherOriginsUnmarked(t *testing.T) {
+	for _, config := range []CorsConfiguration{dashboardCors, {}} {
+		called := false
+		handler := Cors(config, func(http.ResponseWriter, *http.Request) { called = true })
+		request := preflight("https://evil.example.com", "GET", "")
+		recorder := httptest.NewRecorder()
+
+		handler(recorder, request)
+
+		assert.True(t, called)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCorsWithAnyOriginWillNotEchoTheOrigin(t *testing.T) {
+	handler := Cors(CorsConfiguration{AllowedOrigins: []string{"*"}, MaxAge: time.Hour}, func(http.ResponseWriter, *http.Request) {})
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, preflight("https://anywhere.example.com", "GET", ""))
+
+	assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "3600", recorder.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestPreflightsWillNotNeedAnApiKey(t *testing.T) {
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "CorsTest",
+		DbTimeout:    time.Millisecond / 100,
+		Auth:         AuthConfiguration{Enabled: true},
+		Cors:         dashboardCors,
+	})
+	defer handler.Close()
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, preflight("https://dashboard.example.com", "POST", "Authorization"))
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set("Origin", "https://dashboard.example.com")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "https://dashboard.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type EventConfiguration struct {
+	HistorySize       int
+	SubscriberBuffer  int
+	KeepAliveInterval time.Duration
+}
+
+func (config EventConfiguration) withDefaults() EventConfiguration {
+	if config.HistorySize <= 0 {
+		config.HistorySize = 256
+	}
+	if config.SubscriberBuffer <= 0 {
+		config.SubscriberBuffer = 64
+	}
+	if config.KeepAliveInterval <= 0 {
+		config.KeepAliveInterval = 15 * time.Second
+	}
+	return config
+}
+
+// EventBus fans repository events out to attached publishers and to stream subscribers, keeping a short history
+// so that subscribers can resume after reconnecting.
+type EventBus struct {
+	config      EventConfiguration
+	mutex       sync.Mutex
+	history     []api.Event
+	publishers  []EventPublisher
+	subscribers map[*EventSubscription]bool
+	closed      bool
+}
+
+type EventSubscription struct {
+	events chan api.Event
+}
+
+func newEventBus(config EventConfiguration) *EventBus {
+	return &EventBus{
+		config:      config.withDefaults(),
+		subscribers: map[*EventSubscription]bool{},
+	}
+}
+
+func (bus *EventBus) Attach(publisher EventPublisher) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.publishers = append(bus.publishers, publisher)
+}
+
+func (bus *EventBus) Publish(event api.Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if bus.closed {
+		return
+	}
+
+	bus.history = append(bus.history, event)
+	if overflow := len(bus.history) - bus.config.HistorySize; overflow > 0 {
+		bus.history = bus.history[overflow:]
+	}
+
+	for _, publisher := range bus.publishers {
+		publisher.Publish(event)
+	}
+
+	for subscription := range bus.subscribers {
+		select {
+		case subscription.events <- event:
+		default:
+			// The subscriber is not keeping up; drop it so it can reconnect and resume from its last event.
+			bus.removeSubscription(subscription)
+		}
+	}
+}
+
+// Subscribe returns the events published after lastEventId that are still in the history, along with a
+// subscription for events published from now on. When lastEventId is empty nothing is replayed; when it is no
+// longer in the history the whole history is replayed.
+func (bus *EventBus) Subscribe(lastEventId string) ([]api.Event, *EventSubscription) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	subscription := &EventSubscription{events: make(chan api.Event, bus.config.SubscriberBuffer)}
+	if bus.closed {
+		close(subscription.events)
+		return nil, subscription
+	}
+	bus.subscribers[subscription] = true
+
+	return bus.eventsAfter(lastEventId), subscription
+}
+
+func (bus *EventBus) eventsAfter(lastEventId string) []api.Event {
+	if lastEventId == "" {
+		return nil
+	}
+	for index, event := range bus.history {
+		if event.ID == lastEventId {
+			return append([]api.Event{}, bus.history[index+1:]...)
+		}
+	}
+	return append([]api.Event{}, bus.history...)
+}
+
+func (bus *EventBus) Unsubscribe(subscription *EventSubscription) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.removeSubscription(subscription)
+}
+
+func (bus *EventBus) removeSubscription(subscription *EventSubscription) {
+	if bus.subscribers[subscription] {
+		delete(bus.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// Close ends every open subscription. Events published afterwards are discarded.
+func (bus *EventBus) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.closed = true
+	for subscription := range bus.subscribers {
+		bus.removeSubscription(subscription)
+	}
+}
+
+func streamEvents(writer http.ResponseWriter, request *http.Request, _ httprouter.Params, context *Context) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, subscription := context.Events.Subscribe(request.Header.Get("Last-Event-ID"))
+	defer context.Events.Unsubscribe(subscription)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+
+	tenant := TenantFrom(request.Context())
+	for _, event := range backlog {
+		if event.Tenant != tenant {
+			continue
+		}
+		if err := writeServerSentEvent(writer, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(context.Events.config.KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, open := <-subscription.events:
+			if !open {
+				return
+			}
+			if event.Tenant != tenant {
+				continue
+			}
+			if err := writeServerSentEvent(writer, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-request.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(writer http.ResponseWriter, event api.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
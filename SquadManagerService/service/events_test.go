@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBusWillReplayEventsAfterLastEventId(t *testing.T) {
+	bus := newEventBus(EventConfiguration{})
+	defer bus.Close()
+	first := api.NewEvent(api.SquadCreated)
+	second := api.NewEvent(api.MemberUpserted)
+	third := api.NewEvent(api.MemberUpserted)
+	bus.Publish(first)
+	bus.Publish(second)
+	bus.Publish(third)
+
+	backlog, subscription := bus.Subscribe(first.ID)
+	defer bus.Unsubscribe(subscription)
+
+	assert.Equal(t, []api.Event{second, third}, backlog)
+}
+
+func TestEventBusWillReplayWholeHistoryWhenLastEventIdIsUnknown(t *testing.T) {
+	bus := newEventBus(EventConfiguration{HistorySize: 2})
+	defer bus.Close()
+	bus.Publish(api.NewEvent(api.SquadCreated))
+	second := api.NewEvent(api.MemberUpserted)
+	third := api.NewEvent(api.MemberUpserted)
+	bus.Publish(second)
+	bus.Publish(third)
+
+	backlog, subscription := bus.Subscribe("forgotten")
+	defer bus.Unsubscribe(subscription)
+
+	assert.Equal(t, []api.Event{second, third}, backlog)
+}
+
+func TestEventBusWillDropSubscribersThatFallBehind(t *testing.T) {
+	bus := newEventBus(EventConfiguration{SubscriberBuffer: 1})
+	defer bus.Close()
+	_, subscription := bus.Subscribe("")
+
+	bus.Publish(api.NewEvent(api.SquadCreated))
+	bus.Publish(api.NewEvent(api.SquadCreated))
+
+	<-subscription.events
+	_, open := <-subscription.events
+	assert.False(t, open)
+}
+
+func TestEventsStreamWillSendPublishedEvents(t *testing.T) {
+	handler := MakeMainHandler(Configuration{Host: "0.0.0.0", DatabaseName: "EventsTest", DbTimeout: time.Second / 100})
+	defer handler.Close()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	missed := api.NewEvent(api.SquadCreated)
+	handler.context.Events.Publish(api.NewEvent(api.SquadCreated))
+	handler.context.Events.Publish(missed)
+
+	request, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	request.Header.Set("Last-Event-ID", handler.context.Events.history[0].ID)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	published := api.NewEvent(api.MemberUpserted)
+	handler.context.Events.Publish(published)
+
+	reader := bufio.NewReader(response.Body)
+	assert.Equal(t, missed, readServerSentEvent(t, reader))
+	assert.Equal(t, published, readServerSentEvent(t, reader))
+}
+
+func readServerSentEvent(t *testing.T, reader *bufio.Reader) api.Event {
+	var event api.Event
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return event
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 )
 
 type ResponseEntity struct {
@@ -19,17 +25,130 @@ func (handler ThinHandler) With(service *Context) httprouter.Handle {
 	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 		entity, err := handler(request, params)
 		if err != nil {
-			writer.WriteHeader(500)
-			json.NewEncoder(writer).Encode(err)
+			service.Logger.forRequest(request).Error("request failed",
+				"method", request.Method,
+				"route", routeTemplate(request),
+				"error", err,
+			)
+			entity = ResponseEntity{code: errorStatus(err)}
+		}
+
+		respond(writer, request, entity)
+	}
+}
+
+// headed carries headers to send along with the value of a response.
+type headed struct {
+	value  interface{}
+	header http.Header
+}
+
+func respond(writer http.ResponseWriter, request *http.Request, entity ResponseEntity) {
+	if headed, ok := entity.value.(headed); ok {
+		for key, values := range headed.header {
+			writer.Header()[key] = values
+		}
+		entity.value = headed.value
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(entity.code)
+	if entity.code == http.StatusNoContent {
+		return
+	}
+	json.NewEncoder(writer).Encode(responseBody(request, entity))
+}
+
+// errorStatus tells the caller whether to retry: storage that cannot be reached, or a request abandoned by its caller,
+// is unavailable, while a request that ran out of time timed out at the gateway.
+func errorStatus(err error) int {
+	if err == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	if _, ok := err.(*KeySetUnavailableError); ok {
+		return http.StatusServiceUnavailable
+	}
+	if err == context.Canceled || isStorageUnavailable(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// withRequestTimeout bounds the work done for a request by the configured timeout, as well as by the caller staying
+// connected, which the request's own context already tracks. A timeout of zero leaves only the latter.
+func withRequestTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// responseBody describes errors in a consistent shape carrying the request id. Error responses with a body of their
+// own, such as a failed readiness report, are left as they are.
+func responseBody(request *http.Request, entity ResponseEntity) interface{} {
+	if entity.code < http.StatusBadRequest {
+		return entity.value
+	}
+
+	switch value := entity.value.(type) {
+	case nil:
+		return api.Error{Message: http.StatusText(entity.code), RequestID: RequestId(request.Context())}
+	case error:
+		return api.Error{Message: value.Error(), RequestID: RequestId(request.Context())}
+	}
+	return entity.value
+}
+
+type RawHandler func(_ http.ResponseWriter, _ *http.Request, _ httprouter.Params, _ *Context)
+
+func (handler RawHandler) With(service *Context) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		handler(writer, request, params, service)
+	}
+}
+
+const ndjsonContentType = "application/x-ndjson"
+
+// NegotiatedHandler serves requests accepting NDJSON with a handler streaming one value per line, and any other as
+// JSON.
+type NegotiatedHandler struct {
+	Json   ContextualHandler
+	Ndjson ContextualHandler
+}
+
+func (handler NegotiatedHandler) With(service *Context) httprouter.Handle {
+	json := handler.Json.With(service)
+	ndjson := handler.Ndjson.With(service)
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if accepts(request, ndjsonContentType) {
+			ndjson(writer, request, params)
 			return
 		}
+		json(writer, request, params)
+	}
+}
 
-		writer.WriteHeader(entity.code)
-		json.NewEncoder(writer).Encode(entity.value)
+// accepts tells whether the Accept header of the request names the media type.
+func accepts(request *http.Request, mediaType string) bool {
+	for _, accepted := range strings.Split(request.Header.Get("Accept"), ",") {
+		if parsed, _, err := mime.ParseMediaType(strings.TrimSpace(accepted)); err == nil && parsed == mediaType {
+			return true
+		}
 	}
+	return false
+}
+
+type ServiceHandler func(_ *http.Request, _ *Context) (ResponseEntity, error)
+
+func (handler ServiceHandler) With(service *Context) httprouter.Handle {
+	return ThinHandler(func(request *http.Request, _ httprouter.Params) (ResponseEntity, error) {
+		return handler(request, service)
+	}).With(service)
 }
 
-type Handler func(_ *http.Request, _ httprouter.Params, _ *SquadRepository) (ResponseEntity, error)
+type Handler func(_ context.Context, _ *http.Request, _ httprouter.Params, _ *SquadRepository) (ResponseEntity, error)
 
 func (handler Handler) With(service *Context) httprouter.Handle {
 
@@ -37,38 +156,78 @@ func (handler Handler) With(service *Context) httprouter.Handle {
 		request *http.Request,
 		params httprouter.Params,
 	) (ResponseEntity, error) {
+		ctx, cancel := withRequestTimeout(request.Context(), service.RequestTimeout)
+		defer cancel()
+
+		repository, err := service.repository(ctx)
+		if unknown, ok := err.(*UnknownTenantError); ok {
+			return ResponseEntity{unknown, http.StatusNotFound}, nil
+		} else if err != nil {
+			return ResponseEntity{}, err
+		}
+		defer repository.Close()
+		return handler(ctx, request, params, repository)
+
+	}).With(service)
+}
+
+// ControlHandler works with the storage of the whole service rather than that of the request's tenant.
+type ControlHandler func(_ context.Context, _ *http.Request, _ httprouter.Params, _ *SquadRepository) (ResponseEntity, error)
+
+func (handler ControlHandler) With(service *Context) httprouter.Handle {
+	return ThinHandler(func(
+		request *http.Request,
+		params httprouter.Params,
+	) (ResponseEntity, error) {
+		ctx, cancel := withRequestTimeout(request.Context(), service.RequestTimeout)
+		defer cancel()
 
 		repository, err := service.RepositoryFactory.Repository()
 		if err != nil {
 			return ResponseEntity{}, err
 		}
 		defer repository.Close()
-		return handler(request, params, repository)
-
+		return handler(ctx, request, params, repository)
 	}).With(service)
 }
 
-type NoInputHandler func(_ *SquadRepository) (ResponseEntity, error)
+type NoInputHandler func(_ context.Context, _ *SquadRepository) (ResponseEntity, error)
 
 func (handler NoInputHandler) With(service *Context) httprouter.Handle {
 	return Handler(func(
+		ctx context.Context,
 		request *http.Request,
 		params httprouter.Params,
 		repository *SquadRepository,
 	) (ResponseEntity, error) {
-		return handler(repository)
+		return handler(ctx, repository)
 	}).With(service)
 }
 
-type SquadHandler func(_ *http.Request, _ *SquadRepository, _ string) (ResponseEntity, error)
+type SquadHandler func(_ context.Context, _ *http.Request, _ *SquadRepository, _ string) (ResponseEntity, error)
 
 func (handler SquadHandler) With(service *Context) httprouter.Handle {
 	return Handler(func(
+		ctx context.Context,
 		request *http.Request,
 		params httprouter.Params,
 		repository *SquadRepository,
 	) (ResponseEntity, error) {
 		squadId := params.ByName("id")
-		return handler(request, repository, squadId)
+		return handler(ctx, request, repository, squadId)
+	}).With(service)
+}
+
+type WebhookHandler func(_ context.Context, _ *http.Request, _ *SquadRepository, _ string) (ResponseEntity, error)
+
+func (handler WebhookHandler) With(service *Context) httprouter.Handle {
+	return Handler(func(
+		ctx context.Context,
+		request *http.Request,
+		params httprouter.Params,
+		repository *SquadRepository,
+	) (ResponseEntity, error) {
+		webhookId := params.ByName("id")
+		return handler(ctx, request, repository, webhookId)
 	}).With(service)
 }
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorStatusWillDistinguishTimeoutsFromUnavailableStorage(t *testing.T) {
+	assert.Equal(t, http.StatusGatewayTimeout, errorStatus(context.DeadlineExceeded))
+	assert.Equal(t, http.StatusGatewayTimeout, errorStatus(timeoutError{}))
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(context.Canceled))
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(&StorageUnavailableError{errors.New("no reachable servers")}))
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(errors.New("no reachable servers")))
+	assert.Equal(t, http.StatusInternalServerError, errorStatus(errors.New("E11000 duplicate key error")))
+}
+
+func TestWithRequestTimeoutWillBoundTheRequestContext(t *testing.T) {
+	request := httptest.NewRequest("GET", "/squad", nil)
+
+	ctx, cancel := withRequestTimeout(request.Context(), time.Millisecond)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Millisecond), deadline, 50*time.Millisecond)
+	<-ctx.Done()
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+func TestWithRequestTimeoutWillEndWhenTheCallerGoesAway(t *testing.T) {
+	callerContext, disconnect := context.WithCancel(context.Background())
+	request := httptest.NewRequest("GET", "/squad", nil).WithContext(callerContext)
+
+	ctx, cancel := withRequestTimeout(request.Context(), time.Minute)
+	defer cancel()
+	disconnect()
+
+	<-ctx.Done()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestThinHandlerWillReportTimeoutsAsGatewayTimeout(t *testing.T) {
+	service := &Context{Logger: newDefaultLogger(LoggingConfiguration{Level: "error"})}
+	handle := ThinHandler(func(request *http.Request, _ httprouter.Params) (ResponseEntity, error) {
+		return ResponseEntity{}, context.DeadlineExceeded
+	}).With(service)
+	recorder := httptest.NewRecorder()
+
+	handle(recorder, httptest.NewRequest("GET", "/squad", nil), nil)
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"Message":"Gateway Timeout"`)
+}
+
+func TestNegotiatedHandlerWillStreamForCallersAcceptingNdjson(t *testing.T) {
+	served := func(name string) ThinHandler {
+		return func(*http.Request, httprouter.Params) (ResponseEntity, error) {
+			return ResponseEntity{name, http.StatusOK}, nil
+		}
+	}
+	handle := NegotiatedHandler{Json: served("json"), Ndjson: served("ndjson")}.With(&Context{})
+	cases := map[string]string{
+		"":                     `"json"`,
+		"application/json":     `"json"`,
+		"application/x-ndjson": `"ndjson"`,
+		"application/json;q=0.5, application/x-ndjson": `"ndjson"`,
+	}
+
+	for accept, expected := range cases {
+		request := httptest.NewRequest("GET", "/squad", nil)
+		request.Header.Set("Accept", accept)
+		recorder := httptest.NewRecorder()
+
+		handle(recorder, request, nil)
+
+		assert.JSONEq(t, expected, recorder.Body.String(), accept)
+	}
+}
+
+func TestStreamedSquadListsWillRefuseALimit(t *testing.T) {
+	handler := MakeMainHandler(Configuration{Host: "missing", DatabaseName: "StreamTest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	request := httptest.NewRequest("GET", "/squad?limit=10", nil)
+	request.Header.Set("Accept", "application/x-ndjson")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "streamed listings are not paged")
+}
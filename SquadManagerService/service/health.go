@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type HealthConfiguration struct {
+	ReadinessTimeout time.Duration
+	FailFast         bool
+}
+
+func (config HealthConfiguration) withDefaults() HealthConfiguration {
+	if config.ReadinessTimeout <= 0 {
+		config.ReadinessTimeout = 2 * time.Second
+	}
+	return config
+}
+
+func checkLiveness(_ *http.Request, _ httprouter.Params) (ResponseEntity, error) {
+	return ResponseEntity{api.Health{Status: api.StatusOK}, http.StatusOK}, nil
+}
+
+func checkReadiness(_ *http.Request, context *Context) (ResponseEntity, error) {
+	health := context.CheckDependencies()
+	if health.Status != api.StatusOK {
+		return ResponseEntity{health, http.StatusServiceUnavailable}, nil
+	}
+	return ResponseEntity{health, http.StatusOK}, nil
+}
+
+// CheckDependencies reports whether each dependency of the service can currently be reached.
+func (context *Context) CheckDependencies() api.Health {
+	timeout := context.RepositoryFactory.Config.Health.withDefaults().ReadinessTimeout
+	database := checkDependency(func() error {
+		return context.RepositoryFactory.Ping(timeout)
+	})
+
+	health := api.Health{
+		Status:       api.StatusOK,
+		Dependencies: map[string]api.DependencyHealth{"database": database},
+	}
+	for _, dependency := range health.Dependencies {
+		if dependency.Status != api.StatusUp {
+			health.Status = api.StatusUnavailable
+		}
+	}
+	return health
+}
+
+func checkDependency(check func() error) api.DependencyHealth {
+	start := time.Now()
+	err := check()
+	dependency := api.DependencyHealth{Status: api.StatusUp, Duration: time.Since(start).String()}
+	if err != nil {
+		dependency.Status = api.StatusDown
+		dependency.Error = err.Error()
+	}
+	return dependency
+}
+
+// Ping checks that the database answers within the timeout, dialing it first if no session has been opened yet.
+func (factory *SquadRepositoryFactory) Ping(timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		repository, err := factory.Repository()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer repository.Close()
+		result <- repository.session.Ping()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no response within %v", timeout)
+	}
+}
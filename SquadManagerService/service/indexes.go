@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2"
+)
+
+// squadMemberIndexes serve looking members up by squad in the order they were added, by email, and by the dates they
+// cover. They are built in the background, so that the members can still be read and written while they are.
+var squadMemberIndexes = []mgo.Index{
+	{Key: []string{"squadId", "_id"}, Background: true},
+	{Key: []string{"email"}, Background: true},
+	{Key: []string{"range.begin", "range.end"}, Background: true},
+}
+
+// bootstrapIndexes creates whichever indexes are missing from the repository's database, logging each one it
+// creates. Indexes already in place are left alone, so it is safe to run every time the service connects. Failures
+// are only logged, as the service works without indexes, just more slowly.
+func (repository SquadRepository) bootstrapIndexes() {
+	if repository.Config.SkipIndexes {
+		return
+	}
+
+	created, err := repository.ensureIndexes()
+	if repository.logger == nil {
+		return
+	}
+	database := repository.Database().Name
+	for _, key := range created {
+		repository.logger.Info("created index", "database", database, "collection", "squadMember", "key", key)
+	}
+	if err != nil {
+		repository.logger.Warn("could not create indexes", "database", database, "error", err)
+	}
+}
+
+// ensureIndexes returns the keys of the indexes it had to create. mgo remembers which indexes it has ensured and would
+// skip any dropped along with their collection or database since, so that memory is cleared first.
+func (repository SquadRepository) ensureIndexes() ([]string, error) {
+	repository.session.ResetIndexCache()
+	collection := repository.SquadMemberCollection()
+	existing, err := collection.Indexes()
+	if err != nil && !isNamespaceMissing(err) {
+		return nil, err
+	}
+
+	var created []string
+	for _, index := range squadMemberIndexes {
+		if hasIndex(existing, index.Key) {
+			continue
+		}
+		if err := collection.EnsureIndex(index); err != nil {
+			return created, err
+		}
+		created = append(created, strings.Join(index.Key, ","))
+	}
+	return created, nil
+}
+
+func hasIndex(indexes []mgo.Index, key []string) bool {
+	for _, index := range indexes {
+		if strings.Join(index.Key, ",") == strings.Join(key, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// isNamespaceMissing recognises Mongo refusing to list the indexes of a collection that has not been created yet.
+func isNamespaceMissing(err error) bool {
+	queryError, ok := err.(*mgo.QueryError)
+	return ok && queryError.Code == 26
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type JwtConfiguration struct {
+	// Jwks is the URL of the identity provider's JSON Web Key Set, or the path of a file holding it. Tokens are only
+	// accepted when it is set.
+	Jwks          string
+	Issuer        string
+	Audience      string
+	NameClaim     string
+	RolesClaim    string
+	SquadsClaim   string
+	TenantClaim   string
+	CacheDuration time.Duration
+	Leeway        time.Duration
+}
+
+func (config JwtConfiguration) withDefaults() JwtConfiguration {
+	if config.NameClaim == "" {
+		config.NameClaim = "sub"
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	if config.SquadsClaim == "" {
+		config.SquadsClaim = "squads"
+	}
+	if config.TenantClaim == "" {
+		config.TenantClaim = "tenant"
+	}
+	if config.CacheDuration <= 0 {
+		config.CacheDuration = 10 * time.Minute
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = time.Minute
+	}
+	return config
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// KeySetUnavailableError reports that the signing keys could not be loaded, so no token can be checked.
+type KeySetUnavailableError struct {
+	Err error
+}
+
+func (err *KeySetUnavailableError) Error() string {
+	return "could not load JWKS: " + err.Err.Error()
+}
+
+// TokenVerifier checks RS256 and ES256 tokens against the keys published by an identity provider and maps their
+// claims to an identity.
+type TokenVerifier struct {
+	config JwtConfiguration
+	keys   *keySet
+	now    func() time.Time
+}
+
+func newTokenVerifier(config JwtConfiguration) *TokenVerifier {
+	config = config.withDefaults()
+	return &TokenVerifier{
+		config: config,
+		keys:   newKeySet(config.Jwks, config.CacheDuration),
+		now:    time.Now,
+	}
+}
+
+func looksLikeJwt(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify returns the identity a token was issued to. Tokens that are malformed, badly signed, expired or meant for
+// someone else yield errInvalidToken; only a failure to load the signing keys is reported otherwise.
+func (verifier *TokenVerifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	key, err := verifier.keys.lookup(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || !verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return nil, errInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if !verifier.validClaims(claims) {
+		return nil, errInvalidToken
+	}
+	return verifier.identity(claims), nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, value)
+}
+
+// verifySignature only accepts the algorithm that matches the type of key, so a token cannot pick a weaker one.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func (verifier *TokenVerifier) validClaims(claims map[string]interface{}) bool {
+	now := verifier.now()
+	leeway := verifier.config.Leeway
+
+	expiry, ok := numericClaim(claims, "exp")
+	if !ok || now.After(expiry.Add(leeway)) {
+		return false
+	}
+	if notBefore, ok := numericClaim(claims, "nbf"); ok && now.Add(leeway).Before(notBefore) {
+		return false
+	}
+	if verifier.config.Issuer != "" && claims["iss"] != verifier.config.Issuer {
+		return false
+	}
+	if verifier.config.Audience != "" && !containsString(stringsClaim(claims, "aud"), verifier.config.Audience) {
+		return false
+	}
+	return true
+}
+
+func (verifier *TokenVerifier) identity(claims map[string]interface{}) *Identity {
+	name, _ := claims[verifier.config.NameClaim].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	tenant, _ := claims[verifier.config.TenantClaim].(string)
+	identity := &Identity{Name: name, Tenant: tenant, Squads: stringsClaim(claims, verifier.config.SquadsClaim)}
+	for _, role := range stringsClaim(claims, verifier.config.RolesClaim) {
+		// Providers often carry roles meant for other applications, which mean nothing here.
+		if api.Role(role).IsValid() {
+			identity.Roles = append(identity.Roles, api.Role(role))
+		}
+	}
+	return identity
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// stringsClaim reads a claim that may hold a single string, a space-separated list or an array of strings.
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var values []string
+		for _, element := range value {
+			if text, ok := element.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, wanted string) bool {
+	for _, value := range values {
+		if value == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet caches the keys of a JWKS, loading it again once the cache expires or when a token names a key it has not
+// seen, which is how providers roll their keys. Loads are attempted at most once per minimumRefresh, whether or not
+// they succeed, and happen outside the lock so that tokens signed with known keys are checked while a load is slow.
+type keySet struct {
+	source         string
+	ttl            time.Duration
+	minimumRefresh time.Duration
+	client         *http.Client
+	now            func() time.Time
+
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	err       error
+	loading   chan struct{}
+}
+
+func newKeySet(source string, ttl time.Duration) *keySet {
+	return &keySet{
+		source:         source,
+		ttl:            ttl,
+		minimumRefresh: 10 * time.Second,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		now:            time.Now,
+	}
+}
+
+func (keys *keySet) lookup(kid string) (crypto.PublicKey, error) {
+	keys.mutex.Lock()
+	now := keys.now()
+	_, known := keys.keys[kid]
+	expired := keys.keys == nil || now.Sub(keys.fetched) >= keys.ttl
+	due := now.Sub(keys.attempted) >= keys.minimumRefresh
+	if (expired || !known) && due && keys.loading == nil {
+		keys.attempted = now
+		loading := make(chan struct{})
+		keys.loading = loading
+		keys.mutex.Unlock()
+
+		loaded, err := keys.load()
+
+		keys.mutex.Lock()
+		if err != nil {
+			// Keep verifying with the keys we have rather than locking everyone out while the provider is down.
+			keys.err = err
+		} else {
+			keys.keys, keys.fetched, keys.err = loaded, now, nil
+		}
+		keys.loading = nil
+		close(loading)
+	} else if keys.keys == nil && keys.loading != nil {
+		// There is nothing to verify with until the first load finishes.
+		loading := keys.loading
+		keys.mutex.Unlock()
+		<-loading
+		keys.mutex.Lock()
+	}
+	current, err := keys.keys, keys.err
+	keys.mutex.Unlock()
+
+	if current == nil {
+		return nil, &KeySetUnavailableError{err}
+	}
+	if kid == "" && len(current) == 1 {
+		for _, key := range current {
+			return key, nil
+		}
+	}
+	return current[kid], nil
+}
+
+func (keys *keySet) load() (map[string]crypto.PublicKey, error) {
+	var contents []byte
+	var err error
+	if strings.HasPrefix(keys.source, "http://") || strings.HasPrefix(keys.source, "https://") {
+		contents, err = keys.fetch()
+	} else {
+		contents, err = ioutil.ReadFile(keys.source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseJwks(contents)
+}
+
+func (keys *keySet) fetch() ([]byte, error) {
+	response, err := keys.client.Get(keys.source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", keys.source, response.StatusCode)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJwks reads the RSA and P-256 signing keys of a key set, skipping keys of any other kind.
+func parseJwks(contents []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(contents, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, nErr := decodeBigInt(jwk.N)
+			e, eErr := decodeBigInt(jwk.E)
+			if nErr != nil || eErr != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("malformed RSA key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, xErr := decodeBigInt(jwk.X)
+			y, yErr := decodeBigInt(jwk.Y)
+			if xErr != nil || yErr != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("malformed EC key %q", jwk.Kid)
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
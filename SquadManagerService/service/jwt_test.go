@@ -0,0 +1,362 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSigner struct {
+	kid string
+	key crypto.Signer
+}
+
+func newRsaSigner(t *testing.T, kid string) testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid, key}
+}
+
+func newEcdsaSigner(t *testing.T, kid string) testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid, key}
+}
+
+func (signer testSigner) jwk() map[string]string {
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	switch key := signer.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": signer.kid, "use": "sig", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": signer.kid, "crv": "P-256", "x": encode(key.X), "y": encode(key.Y)}
+	}
+	return nil
+}
+
+func (signer testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := signer.key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	return signer.signAs(t, alg, claims)
+}
+
+func (signer testSigner) signAs(t *testing.T, alg string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": signer.kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := signer.key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		copy(signature[32-len(r.Bytes()):32], r.Bytes())
+		copy(signature[64-len(s.Bytes()):], s.Bytes())
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwksDocument(signers ...testSigner) []byte {
+	keys := make([]map[string]string, len(signers))
+	for index, signer := range signers {
+		keys[index] = signer.jwk()
+	}
+	document, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return document
+}
+
+// jwksServer serves the key set of whichever signers are current, counting how often it is fetched.
+type jwksServer struct {
+	*httptest.Server
+	signers atomic.Value
+	fetches int32
+}
+
+func newJwksServer(signers ...testSigner) *jwksServer {
+	server := &jwksServer{}
+	server.signers.Store(signers)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&server.fetches, 1)
+		writer.Write(jwksDocument(server.signers.Load().([]testSigner)...))
+	}))
+	return server
+}
+
+// tamper changes the last character of the payload, leaving the signature as it was.
+func tamper(token string) string {
+	payloadEnd := strings.LastIndex(token, ".")
+	replacement := "A"
+	if token[payloadEnd-1] == 'A' {
+		replacement = "B"
+	}
+	return token[:payloadEnd-1] + replacement + token[payloadEnd:]
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    "https://sso.example.com",
+		"aud":    []string{"squadmanager", "other"},
+		"sub":    "dale",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"roles":  []string{"squad-lead"},
+		"squads": []string{"5a0e6b1bd2b0f6f2a5c3a7e2"},
+	}
+}
+
+func newTestVerifier(jwks string) *TokenVerifier {
+	return newTokenVerifier(JwtConfiguration{Jwks: jwks, Issuer: "https://sso.example.com", Audience: "squadmanager"})
+}
+
+func TestTokenVerifierWillAcceptRS256AndES256Tokens(t *testing.T) {
+	rsaSigner := newRsaSigner(t, "rsa-1")
+	ecdsaSigner := newEcdsaSigner(t, "ec-1")
+	server := newJwksServer(rsaSigner, ecdsaSigner)
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+
+	for _, signer := range []testSigner{rsaSigner, ecdsaSigner} {
+		identity, err := verifier.Verify(signer.sign(t, validClaims()))
+
+		assert.Nil(t, err, signer.kid)
+		expected := &Identity{Name: "dale", Roles: []api.Role{api.RoleSquadLead}, Squads: []string{"5a0e6b1bd2b0f6f2a5c3a7e2"}}
+		assert.Equal(t, expected, identity, signer.kid)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.fetches))
+}
+
+func TestTokenVerifierWillRejectTokensThatFailTheirChecks(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	stranger := newRsaSigner(t, "rsa-1")
+	server := newJwksServer(signer)
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	tokens := map[string]string{
+		"expired":         signer.sign(t, withClaim("exp", time.Now().Add(-time.Hour).Unix())),
+		"without expiry":  signer.sign(t, withClaim("exp", nil)),
+		"not yet valid":   signer.sign(t, withClaim("nbf", time.Now().Add(time.Hour).Unix())),
+		"other issuer":    signer.sign(t, withClaim("iss", "https://evil.example.com")),
+		"other audience":  signer.sign(t, withClaim("aud", "someone-else")),
+		"foreign key":     stranger.sign(t, validClaims()),
+		"algorithm HS256": signer.signAs(t, "HS256", validClaims()),
+		"algorithm none":  signer.signAs(t, "none", validClaims()),
+		"tampered token":  tamper(signer.sign(t, validClaims())),
+		"not a token":     "a.b.c",
+	}
+
+	for name, token := range tokens {
+		identity, err := verifier.Verify(token)
+
+		assert.Equal(t, errInvalidToken, err, name)
+		assert.Nil(t, identity, name)
+	}
+}
+
+func TestTokenVerifierWillAllowForClockSkew(t *testing.T) {
+	signer := newEcdsaSigner(t, "ec-1")
+	server := newJwksServer(signer)
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+
+	_, err := verifier.Verify(signer.sign(t, claims))
+
+	assert.Nil(t, err)
+}
+
+func TestTokenVerifierWillReloadKeySetForUnknownKeys(t *testing.T) {
+	oldSigner := newRsaSigner(t, "rsa-1")
+	newSigner := newRsaSigner(t, "rsa-2")
+	server := newJwksServer(oldSigner)
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+	verifier.keys.minimumRefresh = 0
+
+	_, err := verifier.Verify(oldSigner.sign(t, validClaims()))
+	assert.Nil(t, err)
+
+	server.signers.Store([]testSigner{oldSigner, newSigner})
+	identity, err := verifier.Verify(newSigner.sign(t, validClaims()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "dale", identity.Name)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.fetches))
+}
+
+func TestTokenVerifierWillCacheKeySetUntilItExpires(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	server := newJwksServer(signer)
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+	now := time.Now()
+	verifier.keys.now = func() time.Time { return now }
+
+	verifier.Verify(signer.sign(t, validClaims()))
+	verifier.Verify(signer.sign(t, validClaims()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.fetches))
+
+	now = now.Add(verifier.config.CacheDuration)
+	verifier.Verify(signer.sign(t, validClaims()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.fetches))
+}
+
+func TestTokenVerifierWillReadKeySetFromFile(t *testing.T) {
+	signer := newEcdsaSigner(t, "ec-1")
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(jwksDocument(signer))
+	file.Close()
+	verifier := newTestVerifier(file.Name())
+	claims := validClaims()
+	claims["roles"] = "viewer admin offline_access"
+	delete(claims, "squads")
+
+	identity, err := verifier.Verify(signer.sign(t, claims))
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Name: "dale", Roles: []api.Role{api.RoleViewer, api.RoleAdmin}}, identity)
+}
+
+func TestTokenVerifierWillReportAnUnreachableKeySet(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	server := newJwksServer(signer)
+	server.Close()
+	verifier := newTestVerifier(server.URL)
+
+	_, err := verifier.Verify(signer.sign(t, validClaims()))
+
+	_, unavailable := err.(*KeySetUnavailableError)
+	assert.True(t, unavailable, "%v", err)
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(err))
+}
+
+func TestTokenVerifierWillWaitBeforeRetryingAFailedLoad(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+	now := time.Now()
+	verifier.keys.now = func() time.Time { return now }
+
+	_, first := verifier.Verify(signer.sign(t, validClaims()))
+	_, second := verifier.Verify(signer.sign(t, validClaims()))
+
+	assert.IsType(t, &KeySetUnavailableError{}, first)
+	assert.IsType(t, &KeySetUnavailableError{}, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	now = now.Add(verifier.keys.minimumRefresh)
+	verifier.Verify(signer.sign(t, validClaims()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestTokenVerifierWillCheckKnownKeysWhileTheKeySetReloads(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	unknownSigner := newRsaSigner(t, "rsa-2")
+	release := make(chan struct{})
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		writer.Write(jwksDocument(signer))
+	}))
+	defer server.Close()
+	defer close(release)
+	verifier := newTestVerifier(server.URL)
+	verifier.keys.minimumRefresh = 0
+
+	_, err := verifier.Verify(signer.sign(t, validClaims()))
+	assert.Nil(t, err)
+
+	go verifier.Verify(unknownSigner.sign(t, validClaims()))
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	token := signer.sign(t, validClaims())
+	checked := make(chan error)
+	go func() {
+		_, err := verifier.Verify(token)
+		checked <- err
+	}()
+
+	select {
+	case err := <-checked:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("known keys were not checked while the key set reloaded")
+	}
+}
+
+func TestAuthorizeWillAcceptTokensFromTheIdentityProvider(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	server := newJwksServer(signer)
+	defer server.Close()
+	context := newAuthTestContext(AuthConfiguration{Enabled: true})
+	context.Tokens = newTestVerifier(server.URL)
+	var seen *Identity
+	handle := context.authorize(canView, identityRecorder(&seen))
+
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set("Authorization", "Bearer "+signer.sign(t, validClaims()))
+	recorder := httptest.NewRecorder()
+	handle(recorder, request, nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "dale", seen.Name)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	request = httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set("Authorization", "Bearer "+signer.sign(t, expired))
+	recorder = httptest.NewRecorder()
+	handle(recorder, request, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// LimitsConfiguration protects the service from clients sending too much. Each client, named by its identity once
+// authenticated and by its address otherwise, may make RequestsPerMinute requests, in bursts of up to Burst. Callers
+// of authenticated routes may also be limited by address before their credentials are checked, at
+// AddressRequestsPerMinute in bursts of AddressBurst, which should be looser as many callers can share an address.
+// A rate of zero leaves them unlimited. Public routes are never limited, so probes and scrapes are not turned away.
+type LimitsConfiguration struct {
+	RequestsPerMinute        int
+	Burst                    int
+	AddressRequestsPerMinute int
+	AddressBurst             int
+	MaxBodyBytes             int
+}
+
+func (config LimitsConfiguration) withDefaults() LimitsConfiguration {
+	if config.Burst <= 0 {
+		config.Burst = 10
+	}
+	if config.AddressBurst <= 0 {
+		config.AddressBurst = 10 * config.Burst
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+	return config
+}
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// RateLimiter keeps a token bucket for each client, refilled at a steady rate up to the burst size.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(config LimitsConfiguration) *RateLimiter {
+	config = config.withDefaults()
+	return newRateLimiterAt(config.RequestsPerMinute, config.Burst)
+}
+
+func newAddressRateLimiter(config LimitsConfiguration) *RateLimiter {
+	config = config.withDefaults()
+	return newRateLimiterAt(config.AddressRequestsPerMinute, config.AddressBurst)
+}
+
+func newRateLimiterAt(requestsPerMinute int, burst int) *RateLimiter {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:    float64(requestsPerMinute) / 60,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// allow takes a token from the client's bucket, or says how long until one will be available.
+func (limiter *RateLimiter) allow(client string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.sweep(now)
+	current, ok := limiter.buckets[client]
+	if !ok {
+		current = &bucket{tokens: limiter.burst, updated: now}
+		limiter.buckets[client] = current
+	}
+	current.tokens = math.Min(limiter.burst, current.tokens+now.Sub(current.updated).Seconds()*limiter.rate)
+	current.updated = now
+
+	if current.tokens < 1 {
+		wait := time.Duration((1 - current.tokens) / limiter.rate * float64(time.Second))
+		return false, wait
+	}
+	current.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets that have refilled completely, as those clients would start afresh anyway.
+func (limiter *RateLimiter) sweep(now time.Time) {
+	refill := time.Duration(limiter.burst / limiter.rate * float64(time.Second))
+	if now.Sub(limiter.lastSweep) < refill {
+		return
+	}
+	for client, idle := range limiter.buckets {
+		if now.Sub(idle.updated) >= refill {
+			delete(limiter.buckets, client)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+// rateLimit turns away clients that have used up their requests with 429, telling them when to try again. Clients
+// are named by key.
+func rateLimit(limiter *RateLimiter, key func(request *http.Request) string, next httprouter.Handle) httprouter.Handle {
+	if limiter == nil {
+		return next
+	}
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		allowed, wait := limiter.allow(key(request))
+		if !allowed {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respond(writer, request, ResponseEntity{errRateLimited, http.StatusTooManyRequests})
+			return
+		}
+		next(writer, request, params)
+	}
+}
+
+// clientAddress names a request by the address it came from, which is all that is known of a caller before they are
+// authenticated.
+func clientAddress(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return "address:" + host
+}
+
+// clientIdentity names a request by the API key or token subject it was authenticated with, or by its address when
+// authentication is disabled.
+func clientIdentity(request *http.Request) string {
+	identity := IdentityFrom(request.Context())
+	if identity == nil {
+		return clientAddress(request)
+	}
+	if identity.KeyID != "" {
+		return "key:" + identity.KeyID
+	}
+	return "subject:" + identity.Tenant + "/" + identity.Name
+}
+
+// decodeBody reads a JSON request body into value, answering 413 for bodies over the size limit and 400 for
+// malformed ones.
+func decodeBody(request *http.Request, value interface{}) *ResponseEntity {
+	err := json.NewDecoder(request.Body).Decode(value)
+	if err == nil {
+		return nil
+	}
+	// MaxBytesReader only reports the limit by message.
+	if err.Error() == "http: request body too large" {
+		return &ResponseEntity{err, http.StatusRequestEntityTooLarge}
+	}
+	return &ResponseEntity{err, http.StatusBadRequest}
+}
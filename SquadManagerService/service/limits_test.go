@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimiter(requestsPerMinute int, burst int) (*RateLimiter, *time.Time) {
+	limiter := newRateLimiter(LimitsConfiguration{RequestsPerMinute: requestsPerMinute, Burst: burst})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestRateLimiterWillAllowABurstThenRefillAtTheConfiguredRate(t *testing.T) {
+	limiter, now := newTestRateLimiter(60, 2)
+
+	allowed, _ := limiter.allow("key:a")
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow("key:a")
+	assert.True(t, allowed)
+	allowed, wait := limiter.allow("key:a")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	allowed, _ = limiter.allow("key:b")
+	assert.True(t, allowed, "other clients have buckets of their own")
+
+	*now = now.Add(500 * time.Millisecond)
+	allowed, wait = limiter.allow("key:a")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	*now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.allow("key:a")
+	assert.True(t, allowed)
+}
+
+func TestRateLimiterWillForgetClientsOnceTheirBucketsRefill(t *testing.T) {
+	limiter, now := newTestRateLimiter(60, 5)
+	limiter.allow("key:a")
+	limiter.allow("key:b")
+
+	*now = now.Add(5 * time.Second)
+	limiter.allow("key:b")
+
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestRateLimiterWillBeOffWithoutARate(t *testing.T) {
+	assert.Nil(t, newRateLimiter(LimitsConfiguration{Burst: 5}))
+}
+
+func TestClientsWillBeNamedByTheirAddressAndTheirIdentity(t *testing.T) {
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.RemoteAddr = "203.0.113.7:52110"
+	assert.Equal(t, "address:203.0.113.7", clientAddress(request))
+	assert.Equal(t, "address:203.0.113.7", clientIdentity(request))
+
+	withKey := request.WithContext(context.WithValue(request.Context(), identityKey{}, &Identity{KeyID: "5a0e6b1b", Name: "reporting"}))
+	assert.Equal(t, "key:5a0e6b1b", clientIdentity(withKey))
+
+	withToken := request.WithContext(context.WithValue(request.Context(), identityKey{}, &Identity{Name: "dale", Tenant: "acme"}))
+	assert.Equal(t, "subject:acme/dale", clientIdentity(withToken))
+}
+
+func TestRateLimitedRequestsWillBeToldWhenToRetry(t *testing.T) {
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "LimitsTest",
+		DbTimeout:    time.Millisecond / 100,
+		Limits:       LimitsConfiguration{RequestsPerMinute: 30, Burst: 1},
+	})
+	defer handler.Close()
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest("POST", "/squad", nil))
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, httptest.NewRequest("POST", "/squad", nil))
+	elsewhere := httptest.NewRecorder()
+	fromElsewhere := httptest.NewRequest("POST", "/squad", nil)
+	fromElsewhere.RemoteAddr = "203.0.113.7:52110"
+	handler.ServeHTTP(elsewhere, fromElsewhere)
+
+	assert.NotEqual(t, http.StatusTooManyRequests, first.Code)
+	assert.Equal(t, http.StatusTooManyRequests, second.Code)
+	assert.Equal(t, "2", second.Header().Get("Retry-After"))
+	assert.Contains(t, second.Body.String(), `"Message":"rate limit exceeded"`)
+	assert.NotEqual(t, http.StatusTooManyRequests, elsewhere.Code)
+}
+
+func TestRateLimitWillLeavePublicRoutesOpen(t *testing.T) {
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "LimitsTest",
+		DbTimeout:    time.Millisecond / 100,
+		Limits:       LimitsConfiguration{RequestsPerMinute: 30, Burst: 1, AddressRequestsPerMinute: 30, AddressBurst: 1},
+	})
+	defer handler.Close()
+
+	for _, path := range []string{"/healthz", "/healthz", "/metrics", "/metrics", "/openapi.json"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+	}
+}
+
+func TestRateLimitWillGiveEachCallerFromAnAddressTheirOwnBucket(t *testing.T) {
+	signer := newRsaSigner(t, "rsa-1")
+	server := newJwksServer(signer)
+	defer server.Close()
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "LimitsTest",
+		DbTimeout:    time.Millisecond / 100,
+		Auth: AuthConfiguration{Enabled: true, Jwt: JwtConfiguration{
+			Jwks:     server.URL,
+			Issuer:   "https://sso.example.com",
+			Audience: "squadmanager",
+		}},
+		Limits: LimitsConfiguration{RequestsPerMinute: 30, Burst: 1},
+	})
+	defer handler.Close()
+
+	var codes []int
+	for _, subject := range []string{"dale", "dale", "lee"} {
+		claims := validClaims()
+		claims["sub"] = subject
+		request := httptest.NewRequest("GET", "/squad", nil)
+		request.Header.Set("Authorization", "Bearer "+signer.sign(t, claims))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		codes = append(codes, recorder.Code)
+	}
+
+	assert.NotEqual(t, http.StatusTooManyRequests, codes[0])
+	assert.Equal(t, http.StatusTooManyRequests, codes[1])
+	assert.NotEqual(t, http.StatusTooManyRequests, codes[2])
+}
+
+func TestAddressRateLimitWillApplyBeforeCallersAreAuthenticated(t *testing.T) {
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "LimitsTest",
+		DbTimeout:    time.Millisecond / 100,
+		Auth:         AuthConfiguration{Enabled: true, AdminKey: "s3cret"},
+		Limits:       LimitsConfiguration{RequestsPerMinute: 30, Burst: 1, AddressRequestsPerMinute: 60, AddressBurst: 2},
+	})
+	defer handler.Close()
+
+	var codes []int
+	for range []int{1, 2, 3} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/squad", nil))
+		codes = append(codes, recorder.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+}
+
+func TestDecodeBodyWillRejectBodiesOverTheLimit(t *testing.T) {
+	var squads []interface{}
+	request := httptest.NewRequest("PUT", "/squad", strings.NewReader(`[{"ID": "5a0e6b1bd2b0f6f2a5c3a7e2"}]`))
+	request.Body = http.MaxBytesReader(httptest.NewRecorder(), request.Body, 16)
+
+	rejected := decodeBody(request, &squads)
+
+	if assert.NotNil(t, rejected) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rejected.code)
+	}
+
+	rejected = decodeBody(httptest.NewRequest("PUT", "/squad", strings.NewReader(`[{`)), &squads)
+
+	if assert.NotNil(t, rejected) {
+		assert.Equal(t, http.StatusBadRequest, rejected.code)
+	}
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const RequestIdHeader = "X-Request-ID"
+
+type LoggingConfiguration struct {
+	Format string
+	Level  string
+}
+
+func (config LoggingConfiguration) withDefaults() LoggingConfiguration {
+	if config.Format == "" {
+		config.Format = "json"
+	}
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	return config
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (level LogLevel) String() string {
+	return logLevelNames[level]
+}
+
+func parseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(level), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes leveled log lines as JSON objects or logfmt, each carrying the logger's fields followed by the
+// key-value pairs given for that line.
+type Logger struct {
+	output *logOutput
+	level  LogLevel
+	fields []interface{}
+}
+
+type logOutput struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	format string
+}
+
+func NewLogger(writer io.Writer, config LoggingConfiguration) (*Logger, error) {
+	config = config.withDefaults()
+	if config.Format != "json" && config.Format != "logfmt" {
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+	level, err := parseLogLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{output: &logOutput{writer: writer, format: config.Format}, level: level}, nil
+}
+
+// With returns a logger that adds the given key-value pairs to every line.
+func (logger *Logger) With(keyValues ...interface{}) *Logger {
+	fields := append(append([]interface{}{}, logger.fields...), keyValues...)
+	return &Logger{output: logger.output, level: logger.level, fields: fields}
+}
+
+func (logger *Logger) Debug(message string, keyValues ...interface{}) {
+	logger.log(LevelDebug, message, keyValues)
+}
+
+func (logger *Logger) Info(message string, keyValues ...interface{}) {
+	logger.log(LevelInfo, message, keyValues)
+}
+
+func (logger *Logger) Warn(message string, keyValues ...interface{}) {
+	logger.log(LevelWarn, message, keyValues)
+}
+
+func (logger *Logger) Error(message string, keyValues ...interface{}) {
+	logger.log(LevelError, message, keyValues)
+}
+
+func (logger *Logger) log(level LogLevel, message string, keyValues []interface{}) {
+	if level < logger.level {
+		return
+	}
+
+	pairs := append([]interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339Nano),
+		"level", level.String(),
+		"msg", message,
+	}, logger.fields...)
+	pairs = append(pairs, keyValues...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, "(missing)")
+	}
+
+	var line string
+	if logger.output.format == "logfmt" {
+		line = formatLogfmt(pairs)
+	} else {
+		line = formatJson(pairs)
+	}
+
+	logger.output.mutex.Lock()
+	defer logger.output.mutex.Unlock()
+	io.WriteString(logger.output.writer, line+"\n")
+}
+
+func formatJson(pairs []interface{}) string {
+	fields := make([]string, 0, len(pairs)/2)
+	for index := 0; index < len(pairs); index += 2 {
+		key, _ := json.Marshal(fmt.Sprint(pairs[index]))
+		value, err := json.Marshal(logValue(pairs[index+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(pairs[index+1]))
+		}
+		fields = append(fields, string(key)+":"+string(value))
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+func formatLogfmt(pairs []interface{}) string {
+	fields := make([]string, 0, len(pairs)/2)
+	for index := 0; index < len(pairs); index += 2 {
+		value := fmt.Sprint(logValue(pairs[index+1]))
+		if value == "" || strings.IndexFunc(value, needsQuoting) >= 0 {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, fmt.Sprint(pairs[index])+"="+value)
+	}
+	return strings.Join(fields, " ")
+}
+
+func needsQuoting(character rune) bool {
+	return unicode.IsSpace(character) || character == '"' || character == '=' || !unicode.IsPrint(character)
+}
+
+func logValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case error:
+		return value.Error()
+	case time.Duration:
+		return value.String()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return value
+}
+
+// StandardLogger adapts the logger for libraries that expect a *log.Logger, logging each line at the given level.
+func (logger *Logger) StandardLogger(level LogLevel) *log.Logger {
+	return log.New(standardLogWriter{logger, level}, "", 0)
+}
+
+type standardLogWriter struct {
+	logger *Logger
+	level  LogLevel
+}
+
+func (writer standardLogWriter) Write(line []byte) (int, error) {
+	writer.logger.log(writer.level, strings.TrimSpace(string(line)), nil)
+	return len(line), nil
+}
+
+type requestIdKey struct{}
+
+// RequestId returns the id assigned to the request being served, if any.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// WithRequestId propagates a well-formed X-Request-ID from the caller, or generates a new one, and makes it available
+// to the rest of the request through its context and to the caller through the response header.
+func WithRequestId(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		requestId := request.Header.Get(RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = bson.NewObjectId().Hex()
+		}
+		writer.Header().Set(RequestIdHeader, requestId)
+		next(writer, request.WithContext(context.WithValue(request.Context(), requestIdKey{}, requestId)))
+	}
+}
+
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > 128 {
+		return false
+	}
+	for _, character := range requestId {
+		if character > unicode.MaxASCII || !unicode.IsPrint(character) || unicode.IsSpace(character) {
+			return false
+		}
+	}
+	return true
+}
+
+func (logger *Logger) forRequest(request *http.Request) *Logger {
+	requestLogger := logger.With("requestId", RequestId(request.Context()))
+	if tenant := TenantFrom(request.Context()); tenant != "" {
+		requestLogger = requestLogger.With("tenant", tenant)
+	}
+	if identity := IdentityFrom(request.Context()); identity != nil {
+		requestLogger = requestLogger.With("subject", identity.Name)
+	}
+	return requestLogger
+}
+
+func LogRequest(logger *Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		request, route := withRouteTemplate(request)
+		request, audit := withAudit(request)
+		loggingWriter := &loggingResponseWriter{ResponseWriter: writer, statusCode: http.StatusOK}
+		next(loggingWriter, request)
+
+		requestLogger := logger.forRequest(request)
+		write := requestLogger.Info
+		if loggingWriter.statusCode >= http.StatusInternalServerError {
+			write = requestLogger.Warn
+		}
+		fields := []interface{}{
+			"method", request.Method,
+			"path", request.URL.Path,
+			"route", route.name(),
+		}
+		if audit.tenant != "" {
+			fields = append(fields, "tenant", audit.tenant)
+		}
+		if subject := audit.subject(); subject != "" {
+			fields = append(fields, "subject", subject)
+		}
+		write("request served", append(fields,
+			"status", loggingWriter.statusCode,
+			"duration", time.Since(start),
+			"remoteAddr", request.RemoteAddr,
+		)...)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerWillWriteJsonLinesWithFieldsInOrder(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, err := NewLogger(output, LoggingConfiguration{Format: "json"})
+	assert.Nil(t, err)
+
+	logger.With("requestId", "abc").Info("request served", "status", 200, "duration", 1500*time.Millisecond)
+
+	line := output.String()
+	assert.True(t, strings.HasPrefix(line, `{"time":"`), line)
+	assert.Contains(t, line, `"level":"info","msg":"request served","requestId":"abc","status":200,"duration":"1.5s"}`)
+
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &decoded))
+}
+
+func TestLoggerWillWriteLogfmtAndQuoteValuesWhenNeeded(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, err := NewLogger(output, LoggingConfiguration{Format: "logfmt"})
+	assert.Nil(t, err)
+
+	logger.Error("request failed", "route", "/squad/:id", "error", errors.New("no reachable servers"))
+
+	assert.Contains(t, output.String(), ` level=error msg="request failed" route=/squad/:id error="no reachable servers"`+"\n")
+}
+
+func TestLoggerWillSkipLinesBelowItsLevel(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, err := NewLogger(output, LoggingConfiguration{Level: "warn"})
+	assert.Nil(t, err)
+
+	logger.Debug("hidden")
+	logger.Info("hidden")
+	logger.Warn("shown")
+
+	assert.Equal(t, 1, strings.Count(output.String(), "\n"))
+	assert.Contains(t, output.String(), `"msg":"shown"`)
+}
+
+func TestNewLoggerWillRejectUnknownFormatsAndLevels(t *testing.T) {
+	_, err := NewLogger(&bytes.Buffer{}, LoggingConfiguration{Format: "xml"})
+	assert.EqualError(t, err, `unknown log format "xml"`)
+
+	_, err = NewLogger(&bytes.Buffer{}, LoggingConfiguration{Level: "loud"})
+	assert.EqualError(t, err, `unknown log level "loud"`)
+}
+
+func TestWithRequestIdWillPropagateTheCallersId(t *testing.T) {
+	var seen string
+	handler := WithRequestId(func(writer http.ResponseWriter, request *http.Request) {
+		seen = RequestId(request.Context())
+	})
+	request := httptest.NewRequest("GET", "/squad", nil)
+	request.Header.Set(RequestIdHeader, "trace-1234")
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, request)
+
+	assert.Equal(t, "trace-1234", seen)
+	assert.Equal(t, "trace-1234", recorder.Header().Get(RequestIdHeader))
+}
+
+func TestWithRequestIdWillReplaceMissingOrMalformedIds(t *testing.T) {
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("x", 129)} {
+		var seen string
+		handler := WithRequestId(func(writer http.ResponseWriter, request *http.Request) {
+			seen = RequestId(request.Context())
+		})
+		request := httptest.NewRequest("GET", "/squad", nil)
+		request.Header.Set(RequestIdHeader, incoming)
+		recorder := httptest.NewRecorder()
+
+		handler(recorder, request)
+
+		assert.Len(t, seen, 24, incoming)
+		assert.Equal(t, seen, recorder.Header().Get(RequestIdHeader))
+	}
+}
+
+func TestLogRequestWillIncludeTheRequestIdAndStatus(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger, _ := NewLogger(output, LoggingConfiguration{Format: "logfmt"})
+	handler := WithRequestId(LogRequest(logger, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	}))
+	request := httptest.NewRequest("POST", "/squad", nil)
+	request.Header.Set(RequestIdHeader, "trace-5678")
+
+	handler(httptest.NewRecorder(), request)
+
+	line := output.String()
+	assert.Contains(t, line, `level=info msg="request served" requestId=trace-5678 method=POST path=/squad route=unmatched status=418`)
+}
+
+func TestRepositoryErrorsWillBeLoggedAndReportedWithTheRequestId(t *testing.T) {
+	handler := MakeMainHandler(Configuration{Host: "missing", DatabaseName: "LoggingTest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	output := &bytes.Buffer{}
+	handler.context.Logger, _ = NewLogger(output, LoggingConfiguration{})
+	request := httptest.NewRequest("GET", "/squad/12345", nil)
+	request.Header.Set(RequestIdHeader, "trace-9012")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var body api.Error
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&body))
+	assert.Equal(t, api.Error{Message: "Service Unavailable", RequestID: "trace-9012"}, body)
+
+	line := output.String()
+	assert.Contains(t, line, `"level":"error","msg":"request failed","requestId":"trace-9012","method":"GET","route":"/squad/:id","error":`)
+}
+
+func TestMissingRoutesWillNotReportAnErrorBody(t *testing.T) {
+	handler := MakeMainHandler(Configuration{Host: "missing", DatabaseName: "LoggingTest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/nowhere", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get(RequestIdHeader))
+}
+
+// newDefaultLogger builds a logger writing to stderr for tests that need one but do not look at what it writes.
+func newDefaultLogger(config LoggingConfiguration) *Logger {
+	logger, err := NewLogger(os.Stderr, config)
+	if err != nil {
+		panic(err)
+	}
+	return logger
+}
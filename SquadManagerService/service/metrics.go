@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects request and storage measurements and renders them in the Prometheus text exposition format.
+type Metrics struct {
+	mutex               sync.Mutex
+	requests            map[string]uint64
+	requestDurations    map[string]*histogram
+	repositoryDurations map[string]*histogram
+	sessionCopies       uint64
+	cacheLookups        map[string]uint64
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		requests:            map[string]uint64{},
+		requestDurations:    map[string]*histogram{},
+		repositoryDurations: map[string]*histogram{},
+		cacheLookups:        map[string]uint64{},
+	}
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: defaultBuckets, counts: make([]uint64, len(defaultBuckets))}
+}
+
+func (histogram *histogram) observe(value float64) {
+	for index, bound := range histogram.buckets {
+		if value <= bound {
+			histogram.counts[index]++
+		}
+	}
+	histogram.sum += value
+	histogram.count++
+}
+
+func (metrics *Metrics) observeRequest(method string, route string, code int, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.requests[labels("method", method, "route", route, "code", strconv.Itoa(code))]++
+	observeInto(metrics.requestDurations, labels("method", method, "route", route), duration)
+}
+
+func (metrics *Metrics) observeRepositoryOperation(operation string, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	observeInto(metrics.repositoryDurations, labels("operation", operation), duration)
+}
+
+func (metrics *Metrics) sessionCopied() {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.sessionCopies++
+}
+
+func (metrics *Metrics) observeCacheLookup(hit bool) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.cacheLookups[labels("result", result)]++
+}
+
+func observeInto(histograms map[string]*histogram, key string, duration time.Duration) {
+	observed, ok := histograms[key]
+	if !ok {
+		observed = newHistogram()
+		histograms[key] = observed
+	}
+	observed.observe(duration.Seconds())
+}
+
+// labels renders label pairs in the exposition format, which also serves as the key for each series.
+func labels(pairs ...string) string {
+	rendered := make([]string, 0, len(pairs)/2)
+	for index := 0; index < len(pairs); index += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[index+1])
+		rendered = append(rendered, fmt.Sprintf(`%s="%s"`, pairs[index], value))
+	}
+	return strings.Join(rendered, ",")
+}
+
+// Instrument records the count and duration of each request, labelled by the template of the route that served it.
+func (metrics *Metrics) Instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		request, route := withRouteTemplate(request)
+		recordingWriter := &loggingResponseWriter{ResponseWriter: writer, statusCode: http.StatusOK}
+		next(recordingWriter, request)
+		metrics.observeRequest(request.Method, route.name(), recordingWriter.statusCode, time.Since(start))
+	}
+}
+
+type squadCounts struct {
+	squads  int
+	members int
+}
+
+func (metrics *Metrics) Expose(writer io.Writer, counts *squadCounts) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	writeHeader(writer, "squadmanager_http_requests_total", "counter", "HTTP requests served, by route template and status code.")
+	for _, key := range sortedKeys(metrics.requests) {
+		fmt.Fprintf(writer, "squadmanager_http_requests_total{%s} %d\n", key, metrics.requests[key])
+	}
+
+	writeHistograms(writer, "squadmanager_http_request_duration_seconds", "HTTP request latency, by route template.", metrics.requestDurations)
+	writeHistograms(writer, "squadmanager_repository_operation_duration_seconds", "Repository operation latency, by operation.", metrics.repositoryDurations)
+
+	writeHeader(writer, "squadmanager_repository_sessions_copied_total", "counter", "Database sessions copied from the parent session.")
+	fmt.Fprintf(writer, "squadmanager_repository_sessions_copied_total %d\n", metrics.sessionCopies)
+
+	writeHeader(writer, "squadmanager_listing_cache_lookups_total", "counter", "Squad listings looked up in the cache, by whether they were found.")
+	for _, key := range sortedKeys(metrics.cacheLookups) {
+		fmt.Fprintf(writer, "squadmanager_listing_cache_lookups_total{%s} %d\n", key, metrics.cacheLookups[key])
+	}
+
+	if counts != nil {
+		writeHeader(writer, "squadmanager_squads", "gauge", "Squads currently stored.")
+		fmt.Fprintf(writer, "squadmanager_squads %d\n", counts.squads)
+		writeHeader(writer, "squadmanager_squad_members", "gauge", "Squad members currently stored.")
+		fmt.Fprintf(writer, "squadmanager_squad_members %d\n", counts.members)
+	}
+}
+
+func writeHeader(writer io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeHistograms(writer io.Writer, name string, help string, histograms map[string]*histogram) {
+	writeHeader(writer, name, "histogram", help)
+	keys := make([]string, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		observed := histograms[key]
+		for index, bound := range observed.buckets {
+			fmt.Fprintf(writer, "%s_bucket{%s,le=\"%s\"} %d\n", name, key, formatBound(bound), observed.counts[index])
+		}
+		fmt.Fprintf(writer, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key, observed.count)
+		fmt.Fprintf(writer, "%s_sum{%s} %s\n", name, key, strconv.FormatFloat(observed.sum, 'g', -1, 64))
+		fmt.Fprintf(writer, "%s_count{%s} %d\n", name, key, observed.count)
+	}
+}
+
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]uint64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func serveMetrics(writer http.ResponseWriter, request *http.Request, _ httprouter.Params, context *Context) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	context.Metrics.Expose(writer, context.countSquads(request.Context()))
+}
+
+// countSquads reports the stored squad and member counts, or nil when the database cannot be reached in time.
+func (context *Context) countSquads(ctx context.Context) *squadCounts {
+	ctx, cancel := withRequestTimeout(ctx, context.RequestTimeout)
+	defer cancel()
+
+	repository, err := context.RepositoryFactory.Repository()
+	if err != nil {
+		return nil
+	}
+	defer repository.Close()
+
+	counts, err := repository.countSquads(ctx)
+	if err != nil {
+		return nil
+	}
+	return counts
+}
+
+func (repository SquadRepository) countSquads(ctx context.Context) (*squadCounts, error) {
+	defer repository.observe("countSquads", time.Now())
+	counts := &squadCounts{}
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		var err error
+		if counts.squads, err = repository.SquadCollection().Count(); err != nil {
+			return err
+		}
+		counts.members, err = repository.SquadMemberCollection().Count()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
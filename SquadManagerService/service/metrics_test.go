@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsWillLabelRequestsByRouteTemplate(t *testing.T) {
+	handler := MakeMainHandler(Configuration{Host: "missing", DatabaseName: "MetricsTest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+
+	for _, path := range []string{"/healthz", "/healthz", "/squad/12345", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	assert.Contains(t, body, `squadmanager_http_requests_total{method="GET",route="/healthz",code="200"} 2`)
+	assert.Contains(t, body, `squadmanager_http_requests_total{method="GET",route="/squad/:id",code="503"} 1`)
+	assert.Contains(t, body, `squadmanager_http_requests_total{method="GET",route="unmatched",code="404"} 1`)
+	assert.Contains(t, body, `squadmanager_http_request_duration_seconds_count{method="GET",route="/healthz"} 2`)
+	assert.NotContains(t, body, "12345")
+	assert.NotContains(t, body, "squadmanager_squads ")
+}
+
+func TestMetricsWillRenderHistogramBuckets(t *testing.T) {
+	metrics := newMetrics()
+	metrics.observeRepositoryOperation("listSquads", 20*time.Millisecond)
+	metrics.observeRepositoryOperation("listSquads", 2*time.Second)
+	metrics.sessionCopied()
+	exposed := &bytes.Buffer{}
+
+	metrics.Expose(exposed, &squadCounts{squads: 3, members: 7})
+
+	body := exposed.String()
+	assert.Contains(t, body, "# TYPE squadmanager_repository_operation_duration_seconds histogram\n")
+	assert.Contains(t, body, `squadmanager_repository_operation_duration_seconds_bucket{operation="listSquads",le="0.01"} 0`)
+	assert.Contains(t, body, `squadmanager_repository_operation_duration_seconds_bucket{operation="listSquads",le="0.025"} 1`)
+	assert.Contains(t, body, `squadmanager_repository_operation_duration_seconds_bucket{operation="listSquads",le="2.5"} 2`)
+	assert.Contains(t, body, `squadmanager_repository_operation_duration_seconds_bucket{operation="listSquads",le="+Inf"} 2`)
+	assert.Contains(t, body, `squadmanager_repository_operation_duration_seconds_count{operation="listSquads"} 2`)
+	assert.Contains(t, body, "squadmanager_repository_sessions_copied_total 1\n")
+	assert.Contains(t, body, "squadmanager_squads 3\n")
+	assert.Contains(t, body, "squadmanager_squad_members 7\n")
+}
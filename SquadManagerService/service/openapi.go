@@ -0,0 +1,548 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+type jsonObject map[string]interface{}
+
+func ref(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items jsonObject) jsonObject {
+	return jsonObject{"type": "array", "items": items}
+}
+
+// readOnly marks a field the service sets, which is ignored in requests.
+func readOnly(schema jsonObject) jsonObject {
+	marked := jsonObject{"readOnly": true}
+	for key, value := range schema {
+		marked[key] = value
+	}
+	return marked
+}
+
+func jsonContent(schema jsonObject) jsonObject {
+	return jsonObject{"application/json": jsonObject{"schema": schema}}
+}
+
+func response(description string, schema jsonObject) jsonObject {
+	return jsonObject{"description": description, "content": jsonContent(schema)}
+}
+
+func noContent(description string) jsonObject {
+	return jsonObject{"description": description}
+}
+
+func problem(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/responses/" + name}
+}
+
+func parameter(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/parameters/" + name}
+}
+
+func pathParameter(name string, description string, schema jsonObject) jsonObject {
+	return jsonObject{"name": name, "in": "path", "required": true, "description": description, "schema": schema}
+}
+
+func requestBody(schema jsonObject) jsonObject {
+	return jsonObject{"required": true, "content": jsonContent(schema)}
+}
+
+var (
+	objectId = jsonObject{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	// squadOrView is a full squad, or one holding only what the include, members and fields parameters asked for.
+	squadOrView = jsonObject{"anyOf": []jsonObject{ref("Squad"), ref("SquadView")}}
+	date        = jsonObject{
+		"type":        "string",
+		"format":      "date-time",
+		"description": "An RFC 3339 date and time, such as 2017-08-01T00:00:00Z.",
+	}
+)
+
+// documentedOperations describes each route MakeMainHandler can register, keyed by method and path as registered.
+// Every operation also answers with the errors common to its kind of route, added as the document is built.
+var documentedOperations = map[Route]jsonObject{
+	{Method: "GET", Path: "/healthz"}: {
+		"summary":   "Report that the service is running",
+		"responses": jsonObject{"200": response("The service is running.", ref("Health"))},
+	},
+	{Method: "GET", Path: "/readyz"}: {
+		"summary": "Report whether the service can reach its dependencies",
+		"responses": jsonObject{
+			"200": response("Every dependency answered.", ref("Health")),
+			"503": response("A dependency did not answer.", ref("Health")),
+		},
+	},
+	{Method: "GET", Path: "/metrics"}: {
+		"summary": "Expose metrics in the Prometheus text format",
+		"responses": jsonObject{"200": jsonObject{
+			"description": "Request, storage and cache metrics.",
+			"content":     jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}},
+		}},
+	},
+	{Method: "GET", Path: "/openapi.json"}: {
+		"summary":   "Describe the API",
+		"responses": jsonObject{"200": response("This document.", jsonObject{"type": "object"})},
+	},
+	{Method: "GET", Path: "/squad"}: {
+		"summary": "List squads",
+		"description": "Lists every squad, or a page of them when limit is given. The next page is linked from the Link " +
+			"header. Callers accepting application/x-ndjson get every squad streamed one per line instead of pages.",
+		"parameters": []jsonObject{
+			parameter("begin"), parameter("end"), parameter("sort"), parameter("limit"), parameter("cursor"),
+			parameter("members"), parameter("include"), parameter("fields"),
+		},
+		"responses": jsonObject{
+			"200": jsonObject{
+				"description": "The squads, in the order asked for.",
+				"headers": jsonObject{
+					"Link":          jsonObject{"description": `The next page, as rel="next".`, "schema": jsonObject{"type": "string"}},
+					"Cache-Control": jsonObject{"description": "How long the listing may be kept.", "schema": jsonObject{"type": "string"}},
+				},
+				"content": jsonObject{
+					"application/json":     jsonObject{"schema": arrayOf(squadOrView)},
+					"application/x-ndjson": jsonObject{"schema": squadOrView},
+				},
+			},
+			"400": problem("BadRequest"),
+		},
+	},
+	{Method: "PUT", Path: "/squad"}: {
+		"summary":     "Replace every squad",
+		"requestBody": requestBody(arrayOf(ref("Squad"))),
+		"responses": jsonObject{
+			"200": response("The squads now stored.", arrayOf(ref("Squad"))),
+			"400": problem("BadRequest"),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "POST", Path: "/squad"}: {
+		"summary":   "Create an empty squad",
+		"responses": jsonObject{"202": response("The id of the new squad.", objectId)},
+	},
+	{Method: "GET", Path: "/squad/:id"}: {
+		"summary": "Get a squad",
+		"parameters": []jsonObject{
+			pathParameter("id", "The squad.", objectId),
+			parameter("begin"), parameter("end"), parameter("members"), parameter("include"), parameter("fields"),
+		},
+		"responses": jsonObject{
+			"200": response("The squad.", squadOrView),
+			"400": problem("BadRequest"),
+			"404": problem("NotFound"),
+		},
+	},
+	{Method: "POST", Path: "/squad/:id"}: {
+		"summary":     "Add a member to a squad, updating it when its id is already there or moving it from another squad",
+		"parameters":  []jsonObject{pathParameter("id", "The squad.", objectId)},
+		"requestBody": requestBody(ref("SquadMember")),
+		"responses": jsonObject{
+			"202": response("The id of the member.", objectId),
+			"400": problem("BadRequest"),
+			"404": problem("NotFound"),
+			"409": response("The member belongs to another squad the caller may not edit.", ref("Error")),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "DELETE", Path: "/squad/:id"}: {
+		"summary":    "Delete a squad along with its members",
+		"parameters": []jsonObject{pathParameter("id", "The squad.", objectId)},
+		"responses":  jsonObject{"204": noContent("The squad is gone."), "404": problem("NotFound")},
+	},
+	{Method: "DELETE", Path: "/squad/:id/member/:memberId"}: {
+		"summary": "Remove a member from a squad",
+		"parameters": []jsonObject{
+			pathParameter("id", "The squad.", objectId),
+			pathParameter("memberId", "The member.", objectId),
+		},
+		"responses": jsonObject{"204": noContent("The member is gone."), "404": problem("NotFound")},
+	},
+	{Method: "GET", Path: "/events"}: {
+		"summary": "Stream changes to squads as server-sent events",
+		"parameters": []jsonObject{{
+			"name":        "Last-Event-ID",
+			"in":          "header",
+			"description": "Resume after this event, replaying those still remembered.",
+			"schema":      jsonObject{"type": "string"},
+		}},
+		"responses": jsonObject{"200": jsonObject{
+			"description": "Each event, with its id and type, carrying an Event as data.",
+			"content":     jsonObject{"text/event-stream": jsonObject{"schema": ref("Event")}},
+		}},
+	},
+	{Method: "GET", Path: "/webhook"}: {
+		"summary":   "List webhooks, without their secrets",
+		"responses": jsonObject{"200": response("The webhooks.", arrayOf(ref("Webhook")))},
+	},
+	{Method: "POST", Path: "/webhook"}: {
+		"summary":     "Register a webhook, generating its secret unless one is given",
+		"requestBody": requestBody(ref("Webhook")),
+		"responses": jsonObject{
+			"202": response("The webhook, with its secret.", ref("Webhook")),
+			"400": problem("BadRequest"),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "GET", Path: "/webhook/:id"}: {
+		"summary":    "Get a webhook, without its secret",
+		"parameters": []jsonObject{pathParameter("id", "The webhook.", objectId)},
+		"responses":  jsonObject{"200": response("The webhook.", ref("Webhook")), "404": problem("NotFound")},
+	},
+	{Method: "PUT", Path: "/webhook/:id"}: {
+		"summary":     "Update a webhook, keeping its secret unless a new one is given",
+		"parameters":  []jsonObject{pathParameter("id", "The webhook.", objectId)},
+		"requestBody": requestBody(ref("Webhook")),
+		"responses": jsonObject{
+			"200": response("The webhook, without its secret.", ref("Webhook")),
+			"400": problem("BadRequest"),
+			"404": problem("NotFound"),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "DELETE", Path: "/webhook/:id"}: {
+		"summary":    "Delete a webhook",
+		"parameters": []jsonObject{pathParameter("id", "The webhook.", objectId)},
+		"responses":  jsonObject{"204": noContent("The webhook is gone."), "404": problem("NotFound")},
+	},
+	{Method: "GET", Path: "/webhook/:id/delivery"}: {
+		"summary":    "List the recent deliveries of a webhook",
+		"parameters": []jsonObject{pathParameter("id", "The webhook.", objectId)},
+		"responses": jsonObject{
+			"200": response("The deliveries.", arrayOf(ref("WebhookDelivery"))),
+			"404": problem("NotFound"),
+		},
+	},
+	{Method: "GET", Path: "/tenant"}: {
+		"summary":   "List tenants",
+		"responses": jsonObject{"200": response("The tenants.", arrayOf(ref("Tenant")))},
+	},
+	{Method: "POST", Path: "/tenant"}: {
+		"summary":     "Create a tenant",
+		"requestBody": requestBody(ref("Tenant")),
+		"responses": jsonObject{
+			"202": response("The tenant.", ref("Tenant")),
+			"400": problem("BadRequest"),
+			"409": problem("Conflict"),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "DELETE", Path: "/tenant/:tenant"}: {
+		"summary":    "Delete a tenant along with all of its data",
+		"parameters": []jsonObject{pathParameter("tenant", "The tenant.", jsonObject{"type": "string"})},
+		"responses":  jsonObject{"204": noContent("The tenant is gone."), "404": problem("NotFound")},
+	},
+	{Method: "GET", Path: "/apikey"}: {
+		"summary":   "List API keys, without the keys themselves",
+		"responses": jsonObject{"200": response("The API keys.", arrayOf(ref("ApiKey")))},
+	},
+	{Method: "POST", Path: "/apikey"}: {
+		"summary":     "Create an API key",
+		"requestBody": requestBody(ref("ApiKey")),
+		"responses": jsonObject{
+			"202": response("The API key, with the key itself, which is never shown again.", ref("ApiKey")),
+			"400": problem("BadRequest"),
+			"413": problem("TooLarge"),
+		},
+	},
+	{Method: "DELETE", Path: "/apikey/:id"}: {
+		"summary":    "Revoke an API key",
+		"parameters": []jsonObject{pathParameter("id", "The API key.", objectId)},
+		"responses":  jsonObject{"204": noContent("The key no longer works."), "404": problem("NotFound")},
+	},
+}
+
+var squadMemberProperties = jsonObject{
+	"ID":    objectId,
+	"Range": ref("Range"),
+	"Email": jsonObject{"type": "string", "format": "email"},
+}
+
+// storageFreeRoutes answer without going to the database, so they never time out waiting for it.
+var storageFreeRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/openapi.json": true}
+
+func openAPISchemas() jsonObject {
+	eventTypes := make([]string, len(api.EventTypes))
+	for index, eventType := range api.EventTypes {
+		eventTypes[index] = string(eventType)
+	}
+	roles := make([]string, len(api.Roles))
+	for index, role := range api.Roles {
+		roles[index] = string(role)
+	}
+
+	return jsonObject{
+		"Range": jsonObject{
+			"type":        "object",
+			"description": "The dates a member belongs to a squad, from Begin up to End.",
+			"required":    []string{"Begin", "End"},
+			"properties":  jsonObject{"Begin": date, "End": date},
+		},
+		"SquadMember": jsonObject{
+			"type":        "object",
+			"description": "A member of a squad.",
+			"required":    []string{"ID", "Range", "Email"},
+			"properties":  squadMemberProperties,
+		},
+		"Squad": jsonObject{
+			"type":        "object",
+			"description": "A squad with its members. Members covering none of a requested date range are left out.",
+			"required":    []string{"ID", "Members"},
+			"properties": jsonObject{
+				"ID":          objectId,
+				"Members":     arrayOf(ref("SquadMember")),
+				"MemberCount": jsonObject{"type": "integer", "description": "Only set when included."},
+			},
+		},
+		"PartialSquadMember": jsonObject{
+			"type":        "object",
+			"description": "A member of a squad holding only the fields asked for.",
+			"properties":  squadMemberProperties,
+		},
+		"SquadView": jsonObject{
+			"type": "object",
+			"description": "A squad holding only what was asked for: its members, with only the fields asked for, " +
+				"their count, or both.",
+			"required": []string{"ID"},
+			"properties": jsonObject{
+				"ID":          objectId,
+				"Members":     arrayOf(ref("PartialSquadMember")),
+				"MemberCount": jsonObject{"type": "integer"},
+			},
+		},
+		"Error": jsonObject{
+			"type":     "object",
+			"required": []string{"Message", "RequestID"},
+			"properties": jsonObject{
+				"Message":   jsonObject{"type": "string"},
+				"RequestID": jsonObject{"type": "string", "description": "Names the request in the service's logs."},
+			},
+		},
+		"Health": jsonObject{
+			"type":     "object",
+			"required": []string{"Status"},
+			"properties": jsonObject{
+				"Status":       jsonObject{"type": "string", "enum": []string{api.StatusOK, api.StatusUnavailable}},
+				"Dependencies": jsonObject{"type": "object", "additionalProperties": ref("DependencyHealth")},
+			},
+		},
+		"DependencyHealth": jsonObject{
+			"type":     "object",
+			"required": []string{"Status", "Duration"},
+			"properties": jsonObject{
+				"Status":   jsonObject{"type": "string", "enum": []string{api.StatusUp, api.StatusDown}},
+				"Duration": jsonObject{"type": "string"},
+				"Error":    jsonObject{"type": "string"},
+			},
+		},
+		"ApiKey": jsonObject{
+			"type":     "object",
+			"required": []string{"Name"},
+			"properties": jsonObject{
+				"ID":      readOnly(objectId),
+				"Name":    jsonObject{"type": "string"},
+				"Role":    jsonObject{"type": "string", "enum": roles, "description": "Keys created without a role are viewers."},
+				"Squads":  jsonObject{"type": "array", "items": objectId, "description": "The squads a squad lead may manage."},
+				"Key":     readOnly(jsonObject{"type": "string", "description": "Only returned when the key is created."}),
+				"Created": readOnly(date),
+				"Revoked": readOnly(date),
+			},
+		},
+		"Tenant": jsonObject{
+			"type":     "object",
+			"required": []string{"ID"},
+			"properties": jsonObject{
+				"ID":      jsonObject{"type": "string", "pattern": tenantIdPattern.String()},
+				"Name":    jsonObject{"type": "string"},
+				"Created": readOnly(date),
+			},
+		},
+		"Webhook": jsonObject{
+			"type":     "object",
+			"required": []string{"URL"},
+			"properties": jsonObject{
+				"ID":     readOnly(objectId),
+				"URL":    jsonObject{"type": "string", "format": "uri"},
+				"Events": jsonObject{"type": "array", "items": ref("EventType"), "description": "Every event when left out."},
+				"Secret": jsonObject{"type": "string", "description": "Signs each delivery; only returned when created."},
+			},
+		},
+		"WebhookDelivery": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"ID":         jsonObject{"type": "string"},
+				"WebhookID":  objectId,
+				"EventID":    jsonObject{"type": "string"},
+				"EventType":  ref("EventType"),
+				"Attempt":    jsonObject{"type": "integer"},
+				"StatusCode": jsonObject{"type": "integer"},
+				"Error":      jsonObject{"type": "string"},
+				"Success":    jsonObject{"type": "boolean"},
+				"Time":       date,
+			},
+		},
+		"EventType": jsonObject{"type": "string", "enum": eventTypes},
+		"Event": jsonObject{
+			"type":     "object",
+			"required": []string{"ID", "Type", "Time"},
+			"properties": jsonObject{
+				"ID":      jsonObject{"type": "string"},
+				"Type":    ref("EventType"),
+				"Time":    date,
+				"Tenant":  jsonObject{"type": "string"},
+				"SquadID": objectId,
+				"Member":  ref("SquadMember"),
+				"Squads":  arrayOf(ref("Squad")),
+			},
+		},
+	}
+}
+
+func openAPIParameters() jsonObject {
+	query := func(name string, description string, schema jsonObject) jsonObject {
+		return jsonObject{"name": name, "in": "query", "description": description, "schema": schema}
+	}
+	// Lists are read from a single comma separated value rather than from repeated parameters.
+	commaSeparated := func(parameter jsonObject) jsonObject {
+		parameter["style"] = "form"
+		parameter["explode"] = false
+		return parameter
+	}
+	return jsonObject{
+		"begin": query("begin", "Only members whose range ends after this date.", date),
+		"end":   query("end", "Only members whose range begins before this date.", date),
+		"sort": query("sort", "Order by creation or member count, descending with a leading dash.",
+			jsonObject{"type": "string", "enum": []string{"created", "-created", "members", "-members"}}),
+		"limit": query("limit", "Squads per page; every squad at once when left out.",
+			jsonObject{"type": "integer", "minimum": 1, "maximum": maxPageSize}),
+		"cursor":  query("cursor", "Where the page starts, as linked from the previous page.", jsonObject{"type": "string"}),
+		"members": query("members", "Whether to return the members of each squad.", jsonObject{"type": "boolean"}),
+		"include": commaSeparated(query("include", "What to embed, comma separated, in place of the members alone.", jsonObject{
+			"type": "array", "items": jsonObject{"type": "string", "enum": []string{"members", "memberCount"}},
+		})),
+		"fields": commaSeparated(query("fields", "The fields of each member to return, comma separated.", jsonObject{
+			"type": "array", "items": jsonObject{"type": "string", "enum": []string{"ID", "Email", "Range"}},
+		})),
+	}
+}
+
+func openAPIResponses() jsonObject {
+	problems := map[string]string{
+		"BadRequest":    "The request is malformed.",
+		"Unauthorized":  "The caller did not authenticate.",
+		"Forbidden":     "The caller may not do this.",
+		"NotFound":      "There is no such thing.",
+		"Conflict":      "It already exists.",
+		"UnknownTenant": "There is no such tenant.",
+		"TooLarge":      "The request body is too large.",
+		"RateLimited":   "The caller made too many requests; Retry-After says when to try again.",
+		"Unavailable":   "The database cannot be reached.",
+		"Timeout":       "The database took too long.",
+	}
+	responses := jsonObject{}
+	for name, description := range problems {
+		responses[name] = response(description, ref("Error"))
+	}
+	return responses
+}
+
+// openAPIDocument describes the routes as registered, leaving out any that are not documented.
+func openAPIDocument(config Configuration, routes []Route) jsonObject {
+	paths := jsonObject{}
+	for _, route := range routes {
+		documented, ok := documentedOperations[Route{Method: route.Method, Path: route.Path}]
+		if !ok {
+			continue
+		}
+		operation := jsonObject{}
+		for key, value := range documented {
+			operation[key] = value
+		}
+		responses := jsonObject{}
+		for code, value := range documented["responses"].(jsonObject) {
+			responses[code] = value
+		}
+		if route.Public {
+			operation["security"] = []jsonObject{}
+		} else {
+			responses["401"] = problem("Unauthorized")
+			responses["403"] = problem("Forbidden")
+			if config.Tenancy.enabled() && responses["404"] == nil {
+				responses["404"] = problem("UnknownTenant")
+			}
+			responses["429"] = problem("RateLimited")
+		}
+		if !storageFreeRoutes[route.Path] {
+			responses["503"] = problem("Unavailable")
+			responses["504"] = problem("Timeout")
+		}
+		operation["responses"] = responses
+
+		path := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = jsonObject{}
+		}
+		paths[path].(jsonObject)[strings.ToLower(route.Method)] = operation
+	}
+
+	document := jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "Squad Manager",
+			"version": "1.0.0",
+			"description": "Keeps track of squads and the dates their members belong to them. Dates are RFC 3339 " +
+				"dates and times.",
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas":    openAPISchemas(),
+			"parameters": openAPIParameters(),
+			"responses":  openAPIResponses(),
+			"securitySchemes": jsonObject{"bearer": jsonObject{
+				"type":        "http",
+				"scheme":      "bearer",
+				"description": "An API key, or a JWT from the configured identity provider.",
+			}},
+		},
+	}
+	if config.Auth.Enabled {
+		document["security"] = []jsonObject{{"bearer": []string{}}}
+	}
+	switch config.Tenancy.Mode {
+	case "path":
+		document["servers"] = []jsonObject{{
+			"url":       "/tenants/{tenant}",
+			"variables": jsonObject{"tenant": jsonObject{"default": "", "description": "The tenant's id."}},
+		}, {"url": "/", "description": "For managing the tenants themselves."}}
+	case "subdomain":
+		document["servers"] = []jsonObject{{
+			"url":       "https://{tenant}." + config.Tenancy.Domain,
+			"variables": jsonObject{"tenant": jsonObject{"default": "", "description": "The tenant's id."}},
+		}, {"url": "https://" + config.Tenancy.Domain, "description": "For managing the tenants themselves."}}
+	}
+	return document
+}
+
+// openAPIPath turns a router path such as /squad/:id into an OpenAPI path such as /squad/{id}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for index, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[index] = "{" + strings.TrimPrefix(segment, ":") + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// serveOpenAPI describes the routes registered so far, which by the time any request arrives are all of them.
+func serveOpenAPI(config Configuration, routes *routeRegistry) ThinHandler {
+	return func(*http.Request, httprouter.Params) (ResponseEntity, error) {
+		return ResponseEntity{openAPIDocument(config, routes.routes), http.StatusOK}, nil
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadOpenAPIDocument(t *testing.T, config Configuration) (*MainHandler, map[string]interface{}) {
+	handler := MakeMainHandler(config)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	return handler, document
+}
+
+func TestOpenAPIDocumentWillDescribeExactlyTheRegisteredRoutes(t *testing.T) {
+	handler, document := loadOpenAPIDocument(t, Configuration{
+		Host:         "missing",
+		DatabaseName: "OpenAPITest",
+		DbTimeout:    time.Millisecond / 100,
+		Tenancy:      TenancyConfiguration{Mode: "path"},
+	})
+	defer handler.Close()
+	paths := document["paths"].(map[string]interface{})
+
+	registered := map[Route]bool{}
+	for _, route := range handler.Routes() {
+		registered[Route{Method: route.Method, Path: route.Path}] = true
+		path, _ := paths[openAPIPath(route.Path)].(map[string]interface{})
+		assert.Contains(t, path, strings.ToLower(route.Method), "undocumented route %s %s", route.Method, route.Path)
+	}
+	for route := range documentedOperations {
+		assert.True(t, registered[route], "documented route %s %s is not registered", route.Method, route.Path)
+	}
+
+	documented := 0
+	for _, path := range paths {
+		documented += len(path.(map[string]interface{}))
+	}
+	assert.Equal(t, len(handler.Routes()), documented)
+}
+
+func TestOpenAPIDocumentWillOnlyReferToComponentsItDefines(t *testing.T) {
+	handler, document := loadOpenAPIDocument(t, Configuration{Host: "missing", DatabaseName: "OpenAPITest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	encoded, _ := json.Marshal(document)
+	components := document["components"].(map[string]interface{})
+
+	references := regexp.MustCompile(`"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(encoded), -1)
+	assert.NotEmpty(t, references)
+	for _, reference := range references {
+		assert.Contains(t, components[reference[1]], reference[2], reference[0])
+	}
+}
+
+func TestOpenAPIDocumentWillDescribeAuthenticationAndDates(t *testing.T) {
+	handler, document := loadOpenAPIDocument(t, Configuration{
+		Host:         "missing",
+		DatabaseName: "OpenAPITest",
+		DbTimeout:    time.Millisecond / 100,
+		Auth:         AuthConfiguration{Enabled: true, AdminKey: "secret"},
+	})
+	defer handler.Close()
+	paths := document["paths"].(map[string]interface{})
+	operation := func(path string, method string) map[string]interface{} {
+		return paths[path].(map[string]interface{})[method].(map[string]interface{})
+	}
+
+	assert.Equal(t, []interface{}{map[string]interface{}{"bearer": []interface{}{}}}, document["security"])
+	assert.Equal(t, []interface{}{}, operation("/healthz", "get")["security"])
+	assert.Contains(t, operation("/squad/{id}", "delete")["responses"], "401")
+	assert.NotContains(t, operation("/healthz", "get")["responses"], "401")
+
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	begin := schemas["Range"].(map[string]interface{})["properties"].(map[string]interface{})["Begin"].(map[string]interface{})
+	assert.Equal(t, "date-time", begin["format"])
+}
+
+func TestOpenAPIDocumentWillDescribeListParametersAsCommaSeparated(t *testing.T) {
+	handler, document := loadOpenAPIDocument(t, Configuration{Host: "missing", DatabaseName: "OpenAPITest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	parameters := document["components"].(map[string]interface{})["parameters"].(map[string]interface{})
+
+	for _, name := range []string{"include", "fields"} {
+		parameter := parameters[name].(map[string]interface{})
+		assert.Equal(t, "form", parameter["style"], name)
+		assert.Equal(t, false, parameter["explode"], name)
+	}
+}
+
+func TestOpenAPIDocumentWillAcceptTheTenantIdsTheServiceDoes(t *testing.T) {
+	handler, document := loadOpenAPIDocument(t, Configuration{Host: "missing", DatabaseName: "OpenAPITest", DbTimeout: time.Millisecond / 100})
+	defer handler.Close()
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	id := schemas["Tenant"].(map[string]interface{})["properties"].(map[string]interface{})["ID"].(map[string]interface{})
+	pattern := regexp.MustCompile(id["pattern"].(string))
+
+	for _, tenant := range []string{"acme", "9lives", "acme-east", "-acme", "Acme", strings.Repeat("a", 41)} {
+		assert.Equal(t, validTenantId(tenant), pattern.MatchString(tenant), tenant)
+	}
+}
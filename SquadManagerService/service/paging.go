@@ -0,0 +1,160 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const maxPageSize = 500
+
+type squadSort string
+
+const (
+	sortByCreation    squadSort = "created"
+	sortByMemberCount squadSort = "members"
+)
+
+// SquadListOptions narrows and orders a listing of squads. A Limit of zero lists every squad at once, and After picks
+// up where a previous page left off.
+type SquadListOptions struct {
+	Begin      *time.Time
+	End        *time.Time
+	Sort       squadSort
+	Descending bool
+	Limit      int
+	After      *squadCursor
+	Projection SquadProjection
+}
+
+func (options SquadListOptions) ranged() bool {
+	return options.Begin != nil || options.End != nil
+}
+
+// sortName is the sort as it is given in the query, with a leading dash for descending order.
+func (options SquadListOptions) sortName() string {
+	if options.Descending {
+		return "-" + string(options.Sort)
+	}
+	return string(options.Sort)
+}
+
+// afterQuery selects the squads that come after the cursor in the listing's order.
+func (options SquadListOptions) afterQuery() bson.M {
+	comparison := "$gt"
+	if options.Descending {
+		comparison = "$lt"
+	}
+	after := options.After
+	if options.Sort == sortByMemberCount {
+		return bson.M{"$or": []bson.M{
+			{"memberCount": bson.M{comparison: after.MemberCount}},
+			{"memberCount": after.MemberCount, "_id": bson.M{comparison: after.ID}},
+		}}
+	}
+	return bson.M{"_id": bson.M{comparison: after.ID}}
+}
+
+// precedes tells whether one squad comes before another in the listing's order.
+func (options SquadListOptions) precedes(first squadSummary, second squadSummary) bool {
+	if options.Descending {
+		first, second = second, first
+	}
+	if options.Sort == sortByMemberCount && first.MemberCount != second.MemberCount {
+		return first.MemberCount < second.MemberCount
+	}
+	return first.ID < second.ID
+}
+
+// squadCursor marks the last squad of a page, so that the next page starts right after it however many squads have
+// been added or removed in the meantime.
+type squadCursor struct {
+	Sort        string        `json:"s"`
+	ID          bson.ObjectId `json:"i"`
+	MemberCount int           `json:"c,omitempty"`
+}
+
+func (cursor squadCursor) summary() squadSummary {
+	return squadSummary{ID: cursor.ID, MemberCount: cursor.MemberCount}
+}
+
+var errInvalidCursor = errors.New("cursor is not one handed out by this service")
+
+func (cursor squadCursor) encode() string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeSquadCursor(value string) (*squadCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor squadCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil || !cursor.ID.Valid() {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// parseSquadListOptions reads the date range, projection, sort, limit and cursor parameters of a squad listing.
+func parseSquadListOptions(request *http.Request) (SquadListOptions, error) {
+	parameters, err := parseSquadParameters(request)
+	if err != nil {
+		return SquadListOptions{}, err
+	}
+	options := SquadListOptions{
+		Begin:      parameters.begin,
+		End:        parameters.end,
+		Sort:       sortByCreation,
+		Projection: parameters.projection,
+	}
+	values := request.URL.Query()
+
+	if sort := values.Get("sort"); sort != "" {
+		options.Descending = strings.HasPrefix(sort, "-")
+		options.Sort = squadSort(strings.TrimPrefix(sort, "-"))
+		if options.Sort != sortByCreation && options.Sort != sortByMemberCount {
+			return SquadListOptions{}, fmt.Errorf("squads can be sorted by created or members, not %q", options.Sort)
+		}
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 1 || options.Limit > maxPageSize {
+			return SquadListOptions{}, fmt.Errorf("limit must be a whole number from 1 to %d", maxPageSize)
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		if options.After, err = decodeSquadCursor(cursor); err != nil {
+			return SquadListOptions{}, err
+		}
+		if options.After.Sort != options.sortName() {
+			return SquadListOptions{}, fmt.Errorf("cursor belongs to a listing sorted by %q", options.After.Sort)
+		}
+	}
+	return options, nil
+}
+
+// nextPageLink links to the page after the cursor, keeping the other parameters of the request. It starts from the
+// URI the caller asked for, as the router only sees the path once any tenant has been taken off it.
+func nextPageLink(request *http.Request, cursor squadCursor) string {
+	target, err := url.ParseRequestURI(request.RequestURI)
+	if err != nil {
+		target = request.URL
+	}
+	next := *target
+	values := request.URL.Query()
+	values.Set("cursor", cursor.encode())
+	next.RawQuery = values.Encode()
+	return fmt.Sprintf(`<%s>; rel="next"`, next.String())
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseSquadListOptionsWillReadEveryParameter(t *testing.T) {
+	cursor := squadCursor{Sort: "-members", ID: bson.NewObjectId(), MemberCount: 3}
+	request := httptest.NewRequest("GET", "/squad?begin=2017-08-01T00:00:00Z&sort=-members&limit=20&members=false&cursor="+cursor.encode(), nil)
+
+	options, err := parseSquadListOptions(request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, SquadListOptions{
+		Begin:      api.Date(2017, 8, 1),
+		Sort:       sortByMemberCount,
+		Descending: true,
+		Limit:      20,
+		After:      &cursor,
+		Projection: SquadProjection{OmitMembers: true},
+	}, options)
+}
+
+func TestParseSquadListOptionsWillDefaultToEverySquadInOrderOfCreation(t *testing.T) {
+	options, err := parseSquadListOptions(httptest.NewRequest("GET", "/squad", nil))
+
+	assert.Nil(t, err)
+	assert.Equal(t, SquadListOptions{Sort: sortByCreation}, options)
+}
+
+func TestParseSquadListOptionsWillRejectBadParameters(t *testing.T) {
+	otherSort := squadCursor{Sort: "created", ID: bson.NewObjectId()}
+	cases := map[string]string{
+		"sort=name":           `squads can be sorted by created or members, not "name"`,
+		"limit=0":             "limit must be a whole number from 1 to 500",
+		"limit=501":           "limit must be a whole number from 1 to 500",
+		"limit=ten":           "limit must be a whole number from 1 to 500",
+		"cursor=not-a-cursor": errInvalidCursor.Error(),
+		"members=some":        `members must be true or false, not "some"`,
+	}
+	cases["sort=members&cursor="+otherSort.encode()] = `cursor belongs to a listing sorted by "created"`
+
+	for query, expected := range cases {
+		_, err := parseSquadListOptions(httptest.NewRequest("GET", "/squad?"+query, nil))
+
+		assert.EqualError(t, err, expected, query)
+	}
+}
+
+func TestPrecedesWillOrderSquadsTheWayMongoSortsThem(t *testing.T) {
+	first, second := bson.ObjectIdHex("5a0e6b1bd2b0f6f2a5c3a7e2"), bson.ObjectIdHex("5a0e6b1bd2b0f6f2a5c3a7e3")
+	small, large := squadSummary{ID: second, MemberCount: 1}, squadSummary{ID: first, MemberCount: 2}
+
+	byCreation := SquadListOptions{Sort: sortByCreation}
+	assert.True(t, byCreation.precedes(large, small))
+	byMemberCount := SquadListOptions{Sort: sortByMemberCount}
+	assert.True(t, byMemberCount.precedes(small, large))
+	assert.True(t, byMemberCount.precedes(squadSummary{ID: first, MemberCount: 1}, small))
+	byMostMembers := SquadListOptions{Sort: sortByMemberCount, Descending: true}
+	assert.True(t, byMostMembers.precedes(large, small))
+	assert.False(t, byMostMembers.precedes(small, small))
+}
+
+func TestNextPageLinkWillKeepTheTenantAndOtherParameters(t *testing.T) {
+	context := &Context{Tenancy: TenancyConfiguration{Mode: "path"}}
+	cursor := squadCursor{Sort: "created", ID: bson.NewObjectId()}
+	var link string
+
+	context.resolveTenant(func(writer http.ResponseWriter, request *http.Request) {
+		link = nextPageLink(request, cursor)
+	})(httptest.NewRecorder(), httptest.NewRequest("GET", "/tenants/acme/squad?limit=2&members=false", nil))
+
+	assert.Equal(t, `</tenants/acme/squad?cursor=`+cursor.encode()+`&limit=2&members=false>; rel="next"`, link)
+}
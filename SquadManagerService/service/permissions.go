@@ -0,0 +1,38 @@
+package service
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+// permission decides whether an authenticated caller may use a route, given the parameters of the matched path.
+type permission func(identity *Identity, params httprouter.Params) bool
+
+// canView lets any caller holding a role read squads.
+func canView(identity *Identity, _ httprouter.Params) bool {
+	for _, role := range identity.Roles {
+		if role.IsValid() {
+			return true
+		}
+	}
+	return false
+}
+
+// canLeadSquad lets admins, and the leads of the squad named by the id parameter, change its members.
+func canLeadSquad(identity *Identity, params httprouter.Params) bool {
+	return mayEditSquad(identity, params.ByName("id"))
+}
+
+// mayEditSquad says whether the caller may change the members of the squad, as admin or as one of its leads.
+func mayEditSquad(identity *Identity, squadId string) bool {
+	return identity.hasRole(api.RoleAdmin) || identity.leads(squadId)
+}
+
+func isAdmin(identity *Identity, _ httprouter.Params) bool {
+	return identity.hasRole(api.RoleAdmin)
+}
+
+// isOperator lets admins who belong to no tenant manage the tenants themselves.
+func isOperator(identity *Identity, _ httprouter.Params) bool {
+	return identity.Tenant == "" && identity.hasRole(api.RoleAdmin)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ledSquadId   = "5a0e6b1bd2b0f6f2a5c3a7e2"
+	otherSquadId = "5a0e6b1bd2b0f6f2a5c3a7e3"
+)
+
+// newPermissionTestHandler serves every route with authentication enabled and storage unreachable, so a request that
+// gets past authorization fails with 503 rather than 401 or 403.
+func newPermissionTestHandler(keys *jwksServer) *MainHandler {
+	return MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "PermissionTest",
+		DbTimeout:    time.Millisecond / 100,
+		Logging:      LoggingConfiguration{Level: "error"},
+		Auth: AuthConfiguration{
+			Enabled: true,
+			Jwt:     JwtConfiguration{Jwks: keys.URL, Issuer: "https://sso.example.com", Audience: "squadmanager"},
+		},
+	})
+}
+
+func TestRoutesWillOnlyServeCallersWithTheRequiredRole(t *testing.T) {
+	signer := newEcdsaSigner(t, "ec-1")
+	keys := newJwksServer(signer)
+	defer keys.Close()
+	handler := newPermissionTestHandler(keys)
+	defer handler.Close()
+
+	callers := map[string]map[string]interface{}{
+		"viewer":       {"roles": "viewer"},
+		"lead":         {"roles": "squad-lead", "squads": []string{ledSquadId}},
+		"other lead":   {"roles": "squad-lead", "squads": []string{otherSquadId}},
+		"admin":        {"roles": "admin"},
+		"without role": {"roles": "offline_access"},
+	}
+	everyRole := []string{"viewer", "lead", "other lead", "admin"}
+	matrix := []struct {
+		method  string
+		path    string
+		allowed []string
+	}{
+		{"GET", "/squad", everyRole},
+		{"PUT", "/squad", []string{"admin"}},
+		{"POST", "/squad", []string{"admin"}},
+		{"GET", "/squad/" + ledSquadId, everyRole},
+		{"POST", "/squad/" + ledSquadId, []string{"lead", "admin"}},
+		{"DELETE", "/squad/" + ledSquadId, []string{"admin"}},
+		{"DELETE", "/squad/" + ledSquadId + "/member/5a0e6b1bd2b0f6f2a5c3a7e4", []string{"lead", "admin"}},
+		{"GET", "/events", everyRole},
+		{"GET", "/webhook", []string{"admin"}},
+		{"POST", "/webhook", []string{"admin"}},
+		{"GET", "/apikey", []string{"admin"}},
+		{"POST", "/apikey", []string{"admin"}},
+		{"DELETE", "/apikey/5a0e6b1bd2b0f6f2a5c3a7e5", []string{"admin"}},
+	}
+
+	for _, route := range matrix {
+		for name, roleClaims := range callers {
+			claims := validClaims()
+			delete(claims, "squads")
+			for claim, value := range roleClaims {
+				claims[claim] = value
+			}
+			// The request is already over, so streams end as soon as they start.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			request := httptest.NewRequest(route.method, route.path, nil).WithContext(ctx)
+			request.Header.Set("Authorization", "Bearer "+signer.sign(t, claims))
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			cell := route.method + " " + route.path + " as " + name
+			if containsString(route.allowed, name) {
+				assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, recorder.Code, cell)
+			} else {
+				assert.Equal(t, http.StatusForbidden, recorder.Code, cell)
+			}
+		}
+	}
+}
+
+func TestRoutesWillChallengeAnonymousCallersExceptOnPublicRoutes(t *testing.T) {
+	keys := newJwksServer(newEcdsaSigner(t, "ec-1"))
+	defer keys.Close()
+	handler := newPermissionTestHandler(keys)
+	defer handler.Close()
+
+	for _, route := range handler.Routes() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(route.Method, route.Path, nil))
+
+		switch route.Path {
+		case "/healthz", "/readyz", "/metrics", "/openapi.json":
+			assert.NotEqual(t, http.StatusUnauthorized, recorder.Code, route.Path)
+		default:
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, route.Method+" "+route.Path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// memberFields maps the fields of a member, as they are named in responses, to those of its document.
+var memberFields = map[string]string{
+	"ID":    "_id",
+	"Email": "email",
+	"Range": "range",
+}
+
+// SquadProjection chooses what a squad response holds besides the squad's id. The zero value is the full squad with
+// all of its members and no count.
+type SquadProjection struct {
+	OmitMembers bool
+	MemberCount bool
+	// MemberFields lists the fields of each member to return, or all of them when empty.
+	MemberFields []string
+}
+
+// full tells whether the squads can be returned just as they are, with every field of every member.
+func (projection SquadProjection) full() bool {
+	return !projection.OmitMembers && len(projection.MemberFields) == 0
+}
+
+// memberSelection is the Mongo projection loading only the fields asked for, or nil to load every field.
+func (projection SquadProjection) memberSelection() bson.M {
+	if len(projection.MemberFields) == 0 {
+		return nil
+	}
+	selection := bson.M{"squadId": 1}
+	for _, field := range projection.MemberFields {
+		selection[memberFields[field]] = 1
+	}
+	return selection
+}
+
+// parseSquadProjection reads the include, fields and members parameters of a squad request. Include names what to embed
+// out of members and memberCount, in place of the members alone.
+func parseSquadProjection(request *http.Request) (SquadProjection, error) {
+	values := request.URL.Query()
+	var projection SquadProjection
+
+	if include := values.Get("include"); include != "" {
+		projection.OmitMembers = true
+		for _, name := range strings.Split(include, ",") {
+			switch strings.TrimSpace(name) {
+			case "members":
+				projection.OmitMembers = false
+			case "memberCount":
+				projection.MemberCount = true
+			case "person":
+				return SquadProjection{}, errors.New("cannot include person: members are only known by their email")
+			default:
+				return SquadProjection{}, fmt.Errorf("cannot include %q: choose from members and memberCount", name)
+			}
+		}
+	}
+
+	if members := values.Get("members"); members != "" {
+		included, err := strconv.ParseBool(members)
+		if err != nil {
+			return SquadProjection{}, fmt.Errorf("members must be true or false, not %q", members)
+		}
+		projection.OmitMembers = !included
+	}
+
+	if fields := values.Get("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if _, known := memberFields[field]; !known {
+				return SquadProjection{}, fmt.Errorf("members have no field %q: choose from ID, Email and Range", field)
+			}
+			projection.MemberFields = append(projection.MemberFields, field)
+		}
+	}
+	return projection, nil
+}
+
+// squadView is a squad showing only what was asked for.
+type squadView struct {
+	ID          api.SquadId
+	Members     interface{} `json:",omitempty"`
+	MemberCount *int        `json:",omitempty"`
+}
+
+func (projection SquadProjection) renderSquads(squads []api.Squad) interface{} {
+	if projection.full() {
+		return squads
+	}
+	views := make([]squadView, len(squads))
+	for index, squad := range squads {
+		views[index] = projection.view(squad)
+	}
+	return views
+}
+
+func (projection SquadProjection) renderSquad(squad api.Squad) interface{} {
+	if projection.full() {
+		return squad
+	}
+	return projection.view(squad)
+}
+
+func (projection SquadProjection) view(squad api.Squad) squadView {
+	view := squadView{ID: squad.ID, MemberCount: squad.MemberCount}
+	if projection.OmitMembers {
+		return view
+	}
+
+	fields := projection.MemberFields
+	if len(fields) == 0 {
+		fields = []string{"ID", "Email", "Range"}
+	}
+	members := make([]map[string]interface{}, len(squad.Members))
+	for index, member := range squad.Members {
+		values := map[string]interface{}{"ID": member.ID, "Email": member.Email, "Range": member.Range}
+		members[index] = map[string]interface{}{}
+		for _, field := range fields {
+			members[index][field] = values[field]
+		}
+	}
+	view.Members = members
+	return view
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseSquadProjectionWillReadIncludeAndFields(t *testing.T) {
+	cases := map[string]SquadProjection{
+		"":                                 {},
+		"include=memberCount":              {OmitMembers: true, MemberCount: true},
+		"include=members,memberCount":      {MemberCount: true},
+		"include=members&fields=Email,ID":  {MemberFields: []string{"Email", "ID"}},
+		"include=memberCount&members=true": {MemberCount: true},
+	}
+
+	for query, expected := range cases {
+		projection, err := parseSquadProjection(httptest.NewRequest("GET", "/squad?"+query, nil))
+
+		assert.Nil(t, err, query)
+		assert.Equal(t, expected, projection, query)
+	}
+}
+
+func TestParseSquadProjectionWillRejectWhatSquadsDoNotHave(t *testing.T) {
+	cases := map[string]string{
+		"include=person":   "cannot include person: members are only known by their email",
+		"include=leaders":  `cannot include "leaders": choose from members and memberCount`,
+		"fields=Email,Age": `members have no field "Age": choose from ID, Email and Range`,
+	}
+
+	for query, expected := range cases {
+		_, err := parseSquadProjection(httptest.NewRequest("GET", "/squad?"+query, nil))
+
+		assert.EqualError(t, err, expected, query)
+	}
+}
+
+func renderJson(projection SquadProjection, squads ...api.Squad) string {
+	encoded, _ := json.Marshal(projection.renderSquads(squads))
+	return string(encoded)
+}
+
+func TestRenderSquadsWillOnlyShowWhatWasAskedFor(t *testing.T) {
+	count := 1
+	squadId := api.SquadId(bson.ObjectIdHex("5a0e6b1bd2b0f6f2a5c3a7e2"))
+	member := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 8, 10)})
+	squad := api.Squad{ID: squadId, Members: []api.SquadMember{member}, MemberCount: &count}
+
+	assert.Equal(t, `[{"ID":"5a0e6b1bd2b0f6f2a5c3a7e2","MemberCount":1}]`,
+		renderJson(SquadProjection{OmitMembers: true, MemberCount: true}, squad))
+	assert.Equal(t, `[{"ID":"5a0e6b1bd2b0f6f2a5c3a7e2","Members":[{"Email":"dale@fake.com"}],"MemberCount":1}]`,
+		renderJson(SquadProjection{MemberFields: []string{"Email"}}, squad))
+	assert.Equal(t, `[{"ID":"5a0e6b1bd2b0f6f2a5c3a7e2","Members":[]}]`,
+		renderJson(SquadProjection{MemberFields: []string{"ID"}}, api.Squad{ID: squadId, Members: []api.SquadMember{}}))
+}
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
@@ -11,42 +15,203 @@ import (
 type SquadRepositoryFactory struct {
 	Config        Configuration
 	parentSession *mgo.Session
+	publisher     EventPublisher
+	metrics       *Metrics
+	logger        *Logger
+	mutex         sync.Mutex
+	closed        bool
 }
 
 func (factory *SquadRepositoryFactory) Close() {
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	factory.closed = true
 	if factory.parentSession != nil {
 		factory.parentSession.Close()
 	}
 }
 
 func (factory *SquadRepositoryFactory) Repository() (*SquadRepository, error) {
-	if factory.parentSession == nil {
-		if err := factory.initParentSession(); err != nil {
-			return nil, err
-		}
+	return factory.TenantRepository("")
+}
+
+// TenantRepository opens the storage of a tenant, which is that of the whole service for the empty tenant.
+func (factory *SquadRepositoryFactory) TenantRepository(tenant string) (*SquadRepository, error) {
+	session, err := factory.copyParentSession()
+	if err != nil {
+		return nil, err
 	}
 
-	repository := SquadRepository{Config: factory.Config, session: factory.parentSession.Copy()}
+	repository := factory.repository(tenant, session)
+	factory.metrics.sessionCopied()
 	return &repository, nil
 }
 
-func (factory *SquadRepositoryFactory) initParentSession() error {
+func (factory *SquadRepositoryFactory) repository(tenant string, session *mgo.Session) SquadRepository {
+	return SquadRepository{
+		Config:    factory.Config,
+		tenant:    tenant,
+		session:   session,
+		publisher: factory.publisher,
+		metrics:   factory.metrics,
+		logger:    factory.logger,
+	}
+}
+
+// copyParentSession copies the session every repository is opened from, dialling it first if need be. The dial
+// happens outside the lock, so that while the database is down requests fail on their own dial rather than queueing
+// behind someone else's; when several succeed at once the first session installed is kept.
+func (factory *SquadRepositoryFactory) copyParentSession() (*mgo.Session, error) {
+	factory.mutex.Lock()
+	if factory.closed {
+		factory.mutex.Unlock()
+		return nil, &StorageUnavailableError{errFactoryClosed}
+	}
+	if factory.parentSession != nil {
+		defer factory.mutex.Unlock()
+		return factory.parentSession.Copy(), nil
+	}
+	factory.mutex.Unlock()
+
 	session, err := mgo.DialWithTimeout(factory.Config.Host, factory.Config.DbTimeout)
-	factory.parentSession = session
-	return err
+	if err != nil {
+		return nil, &StorageUnavailableError{err}
+	}
+
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	if factory.closed {
+		session.Close()
+		return nil, &StorageUnavailableError{errFactoryClosed}
+	}
+	if factory.parentSession != nil {
+		session.Close()
+	} else {
+		factory.parentSession = session
+		go factory.bootstrapIndexes(session.Copy())
+	}
+	return factory.parentSession.Copy(), nil
+}
+
+var errFactoryClosed = errors.New("repository factory is closed")
+
+// bootstrapIndexes makes sure the database of the service, and that of every tenant, has its indexes once connected.
+// It runs apart from the requests, which are served meanwhile, and closes its session when done. Tenants created
+// later get theirs when they are added.
+func (factory *SquadRepositoryFactory) bootstrapIndexes(session *mgo.Session) {
+	defer session.Close()
+	if factory.Config.SkipIndexes {
+		return
+	}
+
+	control := factory.repository("", session)
+	control.bootstrapIndexes()
+	if !factory.Config.Tenancy.enabled() {
+		return
+	}
+
+	var tenants []TenantDocument
+	if err := control.TenantCollection().Find(nil).All(&tenants); err != nil {
+		if factory.logger != nil {
+			factory.logger.Warn("could not list tenants to create their indexes", "error", err)
+		}
+		return
+	}
+	for _, tenant := range tenants {
+		factory.repository(tenant.ID, session).bootstrapIndexes()
+	}
+}
+
+// StorageUnavailableError reports that the database could not be reached at all, as opposed to an operation failing.
+type StorageUnavailableError struct {
+	Err error
+}
+
+func (err *StorageUnavailableError) Error() string {
+	return err.Err.Error()
+}
+
+// isStorageUnavailable recognises both a failed dial and mgo losing every server of an established session, which it
+// reports only by message.
+func isStorageUnavailable(err error) bool {
+	_, unavailable := err.(*StorageUnavailableError)
+	return unavailable || err.Error() == "no reachable servers"
 }
 
 type SquadRepository struct {
-	Config  Configuration
-	session *mgo.Session
+	Config    Configuration
+	tenant    string
+	session   *mgo.Session
+	publisher EventPublisher
+	metrics   *Metrics
+	logger    *Logger
 }
 
 func (repository SquadRepository) Close() {
 	repository.session.Close()
 }
 
+// observe records how long an operation took; call it deferred with the start time.
+func (repository SquadRepository) observe(operation string, start time.Time) {
+	repository.metrics.observeRepositoryOperation(operation, time.Since(start))
+}
+
+// run performs a read against its own copy of the session, giving up as soon as the context is done. mgo cannot
+// abandon a query in flight, so the copy's socket timeout is bounded by the context's deadline and the copy is closed
+// once the operation finishes.
+func (repository SquadRepository) run(ctx context.Context, operation func(repository SquadRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	scoped := repository
+	scoped.session = repository.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			scoped.session.Close()
+			return context.DeadlineExceeded
+		}
+		scoped.session.SetSocketTimeout(remaining)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer scoped.session.Close()
+		done <- operation(scoped)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write performs an operation that changes what is stored. Unlike run, it is not abandoned once it has started: the
+// caller would be told it failed while it went on to take effect and announce itself. It finishes, or fails, within
+// the socket timeout of the session instead, so that the caller learns how it went.
+func (repository SquadRepository) write(ctx context.Context, operation func(repository SquadRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	scoped := repository
+	scoped.session = repository.session.Copy()
+	defer scoped.session.Close()
+	return operation(scoped)
+}
+
+func (repository SquadRepository) publish(event api.Event) {
+	if repository.publisher != nil {
+		event.Tenant = repository.tenant
+		repository.publisher.Publish(event)
+	}
+}
+
 func (repository SquadRepository) Database() *mgo.Database {
-	return repository.session.DB(repository.Config.DatabaseName)
+	return repository.session.DB(tenantDatabase(repository.Config.DatabaseName, repository.tenant))
 }
 
 func (repository SquadRepository) SquadCollection() *mgo.Collection {
@@ -57,31 +222,51 @@ func (repository SquadRepository) SquadMemberCollection() *mgo.Collection {
 	return repository.Database().C("squadMember")
 }
 
-func (repository SquadRepository) addSquad() (api.SquadId, error) {
+func (repository SquadRepository) addSquad(ctx context.Context) (api.SquadId, error) {
+	defer repository.observe("addSquad", time.Now())
 	id := api.SquadId(bson.NewObjectId())
-	collection := repository.SquadCollection()
-	return id, collection.Insert(SquadDocument{bson.ObjectId(id)})
+	return id, repository.write(ctx, func(repository SquadRepository) error {
+		collection := repository.SquadCollection()
+		if err := collection.Insert(SquadDocument{bson.ObjectId(id)}); err != nil {
+			return err
+		}
+
+		event := api.NewEvent(api.SquadCreated)
+		event.SquadID = &id
+		repository.publish(event)
+		return nil
+	})
 }
 
-func (repository SquadRepository) overwriteSquadList(squadList []api.Squad) ([]api.Squad, error) {
+func (repository SquadRepository) overwriteSquadList(ctx context.Context, squadList []api.Squad) ([]api.Squad, error) {
+	defer repository.observe("overwriteSquadList", time.Now())
 
-	if err := clearCollection(repository.SquadCollection()); err != nil {
-		return nil, err
-	}
-	if err := clearCollection(repository.SquadMemberCollection()); err != nil {
-		return nil, err
-	}
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		if err := clearCollection(repository.SquadCollection()); err != nil {
+			return err
+		}
+		if err := clearCollection(repository.SquadMemberCollection()); err != nil {
+			return err
+		}
 
-	squadDocumentList, squadMemberDocumentList := toDocuments(squadList)
+		squadDocumentList, squadMemberDocumentList := toDocuments(squadList)
 
-	if err := insertDocuments(repository.SquadCollection(), squadDocumentList); err != nil {
-		return nil, err
-	}
+		if err := insertDocuments(repository.SquadCollection(), squadDocumentList); err != nil {
+			return err
+		}
 
-	if err := insertDocuments(repository.SquadMemberCollection(), squadMemberDocumentList); err != nil {
+		if err := insertDocuments(repository.SquadMemberCollection(), squadMemberDocumentList); err != nil {
+			return err
+		}
+
+		event := api.NewEvent(api.SquadListOverwritten)
+		event.Squads = squadList
+		repository.publish(event)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
-
 	return squadList, nil
 }
 
@@ -98,17 +283,10 @@ func toDocuments(squadList []api.Squad) ([]interface{}, []interface{}) {
 	return squadDocumentList, squadMemberDocumentList
 }
 
+// clearCollection removes every document from the collection, keeping the collection itself along with its indexes.
 func clearCollection(collection *mgo.Collection) error {
-	count, err := collection.Count()
-	if err != nil {
-		return err
-	}
-
-	if count == 0 {
-		return nil
-	}
-
-	return collection.DropCollection()
+	_, err := collection.RemoveAll(nil)
+	return err
 }
 
 func insertDocuments(collection *mgo.Collection, documentList []interface{}) error {
@@ -123,46 +301,77 @@ func insertDocuments(collection *mgo.Collection, documentList []interface{}) err
 	return nil
 }
 
-func (repository *SquadRepository) getSquad(idString string, begin *time.Time, end *time.Time) (*api.Squad, error) {
+func (repository *SquadRepository) getSquad(ctx context.Context, idString string, begin *time.Time, end *time.Time, projection SquadProjection) (*api.Squad, error) {
+	defer repository.observe("getSquad", time.Now())
 	if !bson.IsObjectIdHex(idString) {
 		return nil, nil
 	}
 
 	squadId := api.SquadId(bson.ObjectIdHex(idString))
 
-	squadCollection := repository.SquadCollection()
-	var squadDocuments []SquadDocument
+	var squad *api.Squad
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		squadCollection := repository.SquadCollection()
+		var squadDocuments []SquadDocument
 
-	if err := squadCollection.FindId(bson.ObjectId(squadId)).All(&squadDocuments); err != nil {
+		if err := squadCollection.FindId(bson.ObjectId(squadId)).All(&squadDocuments); err != nil {
+			return err
+		}
+
+		if len(squadDocuments) == 0 {
+			return nil
+		}
+
+		loaded, err := repository.loadSquad(squadId, begin, end, projection)
+		squad = loaded
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
+	return squad, nil
+}
 
-	if len(squadDocuments) == 0 {
-		return nil, nil
+// loadSquad loads the members of a squad in the range, or only counts them when the members are not wanted.
+func (repository *SquadRepository) loadSquad(squadId api.SquadId, begin *time.Time, end *time.Time, projection SquadProjection) (*api.Squad, error) {
+	query := memberRangeQuery(begin, end)
+	query["squadId"] = bson.ObjectId(squadId)
+	squad := &api.Squad{ID: squadId}
+
+	if projection.OmitMembers {
+		if projection.MemberCount {
+			count, err := repository.SquadMemberCollection().Find(query).Count()
+			if err != nil {
+				return nil, err
+			}
+			squad.MemberCount = &count
+		}
+		return squad, nil
 	}
 
-	return repository.loadSquad(squadId, begin, end)
-}
-
-func (repository *SquadRepository) loadSquad(squadId api.SquadId, begin *time.Time, end *time.Time) (*api.Squad, error) {
-	query := bson.M{"squadId": bson.ObjectId(squadId)}
 	squadMemberDocuments := []SquadMemberDocument{}
-	if err := repository.loadSquadMemberDocuments(query, &squadMemberDocuments); err != nil {
+	members := repository.SquadMemberCollection().Find(query).Select(projection.memberSelection()).Sort("_id")
+	if err := members.All(&squadMemberDocuments); err != nil {
 		return nil, err
 	}
-	return buildSquad(squadId, squadMemberDocuments, begin, end), nil
-}
-
-func (repository *SquadRepository) loadSquadMemberDocuments(query interface{}, squadMemberDocuments *[]SquadMemberDocument) error {
-	return repository.SquadMemberCollection().Find(query).All(squadMemberDocuments)
+	squad.Members = toApiSquadMemberList(squadMemberDocuments)
+	if projection.MemberCount {
+		count := len(squad.Members)
+		squad.MemberCount = &count
+	}
+	return squad, nil
 }
 
-func buildSquad(squadId api.SquadId, squadMemberDocuments []SquadMemberDocument, begin *time.Time, end *time.Time) *api.Squad {
-	squad := &api.Squad{
-		ID:      squadId,
-		Members: api.FilterMembers(toApiSquadMemberList(squadMemberDocuments), begin, end),
+// memberRangeQuery selects the members whose range overlaps the one asked for, as api.FilterMembers would.
+func memberRangeQuery(begin *time.Time, end *time.Time) bson.M {
+	query := bson.M{}
+	if begin != nil {
+		query["range.end"] = bson.M{"$gt": *begin}
 	}
-	return squad
+	if end != nil {
+		query["range.begin"] = bson.M{"$lt": *end}
+	}
+	return query
 }
 
 func toApiSquadMemberList(documents []SquadMemberDocument) []api.SquadMember {
@@ -184,11 +393,112 @@ func toApiSquadMember(document SquadMemberDocument) api.SquadMember {
 	}
 }
 
-func (repository SquadRepository) postSquadMember(squadMember api.SquadMember, squadId string) error {
-	collection := repository.SquadMemberCollection()
-	squadMemberDocument := toSquadMemberDocument(squadMember, api.SquadId(bson.ObjectIdHex(squadId)))
-	_, err := collection.Upsert(bson.M{"_id": squadMemberDocument.ID}, squadMemberDocument)
-	return err
+// postSquadMember adds a member to a squad or updates it there, reporting false when the member belongs to another
+// squad, which it is left in.
+// postSquadMember adds the member to the squad or updates it there. A member of another squad is moved when mayMove
+// allows its squad to be edited, and is left where it is, reporting false, when it does not.
+func (repository SquadRepository) postSquadMember(ctx context.Context, squadMember api.SquadMember, squadId string, mayMove func(fromSquadId string) bool) (bool, error) {
+	defer repository.observe("postSquadMember", time.Now())
+	posted := false
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		collection := repository.SquadMemberCollection()
+		id := api.SquadId(bson.ObjectIdHex(squadId))
+		squadMemberDocument := toSquadMemberDocument(squadMember, id)
+
+		from := squadMemberDocument.SquadID
+		var current SquadMemberDocument
+		err := collection.FindId(squadMemberDocument.ID).Select(bson.M{"squadId": 1}).One(&current)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		if err == nil && current.SquadID != from {
+			if !mayMove(current.SquadID.Hex()) {
+				return nil
+			}
+			from = current.SquadID
+		}
+
+		// Matching the squad it was found in leaves the member alone if it has since been moved somewhere else.
+		query := bson.M{"_id": squadMemberDocument.ID, "squadId": from}
+		if _, err := collection.Upsert(query, squadMemberDocument); err != nil {
+			if mgo.IsDup(err) {
+				return nil
+			}
+			return err
+		}
+		posted = true
+
+		event := api.NewEvent(api.MemberUpserted)
+		event.SquadID = &id
+		event.Member = &squadMember
+		repository.publish(event)
+		return nil
+	})
+	return posted, err
+}
+
+func (repository SquadRepository) deleteSquadMember(ctx context.Context, squadId string, memberId string) (bool, error) {
+	defer repository.observe("deleteSquadMember", time.Now())
+	if !bson.IsObjectIdHex(squadId) || !bson.IsObjectIdHex(memberId) {
+		return false, nil
+	}
+
+	deleted := false
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		query := bson.M{"_id": bson.ObjectIdHex(memberId), "squadId": bson.ObjectIdHex(squadId)}
+		var removed SquadMemberDocument
+		_, err := repository.SquadMemberCollection().Find(query).Apply(mgo.Change{Remove: true}, &removed)
+		if err == mgo.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		id := api.SquadId(removed.SquadID)
+		member := toApiSquadMember(removed)
+		event := api.NewEvent(api.MemberRemoved)
+		event.SquadID = &id
+		event.Member = &member
+		repository.publish(event)
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// deleteSquad removes a squad along with all of its members.
+func (repository SquadRepository) deleteSquad(ctx context.Context, squadId string) (bool, error) {
+	defer repository.observe("deleteSquad", time.Now())
+	if !bson.IsObjectIdHex(squadId) {
+		return false, nil
+	}
+
+	deleted := false
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		id := bson.ObjectIdHex(squadId)
+		if err := repository.SquadCollection().RemoveId(id); err == mgo.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := repository.SquadMemberCollection().RemoveAll(bson.M{"squadId": id}); err != nil {
+			return err
+		}
+
+		deletedId := api.SquadId(id)
+		event := api.NewEvent(api.SquadDeleted)
+		event.SquadID = &deletedId
+		repository.publish(event)
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
 }
 
 func toSquadMemberDocument(squadMember api.SquadMember, squadId api.SquadId) SquadMemberDocument {
@@ -207,38 +517,227 @@ func (repository SquadRepository) findSquadDocuments(query interface{}) ([]Squad
 	return squadDocuments, err
 }
 
-func (repository SquadRepository) listSquads(begin *time.Time, end *time.Time) ([]api.Squad, error) {
-	squadDocuments, err := repository.findSquadDocuments(bson.M{})
+// listSquads lists a page of squads along with their members in the range, and a cursor for the next page when there
+// is one.
+func (repository SquadRepository) listSquads(ctx context.Context, options SquadListOptions) ([]api.Squad, *squadCursor, error) {
+	defer repository.observe("listSquads", time.Now())
+	var summaries []squadSummary
+	var groups []squadMemberGroup
+	var next *squadCursor
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		var err error
+		if summaries, err = repository.findSquadSummaries(options); err != nil {
+			return err
+		}
+		if options.Limit != 0 && len(summaries) > options.Limit {
+			summaries = summaries[:options.Limit]
+			last := summaries[len(summaries)-1]
+			next = &squadCursor{Sort: options.sortName(), ID: last.ID}
+			if options.Sort == sortByMemberCount {
+				next.MemberCount = last.MemberCount
+			}
+		}
+		if options.Projection.OmitMembers {
+			return nil
+		}
+
+		memberQuery := memberRangeQuery(options.Begin, options.End)
+		if options.Limit != 0 {
+			squadIds := make([]bson.ObjectId, len(summaries))
+			for index, summary := range summaries {
+				squadIds[index] = summary.ID
+			}
+			memberQuery["squadId"] = bson.M{"$in": squadIds}
+		}
+		groups, err = repository.groupSquadMembers(memberQuery, options.Projection.memberSelection())
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	membersBySquad := make(map[bson.ObjectId][]SquadMemberDocument, len(groups))
+	for _, group := range groups {
+		membersBySquad[group.SquadID] = group.Members
+	}
+
+	squadList := make([]api.Squad, 0, len(summaries))
+	for _, summary := range summaries {
+		squad := api.Squad{ID: api.SquadId(summary.ID)}
+		if !options.Projection.OmitMembers {
+			squad.Members = toApiSquadMemberList(membersBySquad[summary.ID])
+		}
+		if options.Projection.MemberCount {
+			count := summary.MemberCount
+			squad.MemberCount = &count
+		}
+		squadList = append(squadList, squad)
+	}
+	return squadList, next, nil
+}
+
+// squadSummary is a squad with the number of its members in the range, which is only counted when it is needed.
+type squadSummary struct {
+	ID          bson.ObjectId `bson:"_id"`
+	MemberCount int           `bson:"memberCount"`
+}
+
+// findSquadSummaries picks out a page of squads in order, fetching one squad more than the limit to tell whether
+// another page follows. Members are counted when the count is asked for, when the squads are sorted by it or when
+// only squads with members in the range are listed, by grouping the members rather than loading them.
+func (repository SquadRepository) findSquadSummaries(options SquadListOptions) ([]squadSummary, error) {
+	switch {
+	case options.ranged():
+		return repository.findRangedSquadSummaries(options)
+	case options.Sort == sortByMemberCount:
+		return repository.findSquadSummariesByMemberCount(options)
+	}
 
+	query := bson.M{}
+	if options.After != nil {
+		query = options.afterQuery()
+	}
+	order := "_id"
+	if options.Descending {
+		order = "-_id"
+	}
+	squads := repository.SquadCollection().Find(query).Select(bson.M{"_id": 1}).Sort(order)
+	if options.Limit != 0 {
+		squads = squads.Limit(options.Limit + 1)
+	}
+	var summaries []squadSummary
+	if err := squads.All(&summaries); err != nil {
+		return nil, err
+	}
+	if !options.Projection.MemberCount || len(summaries) == 0 {
+		return summaries, nil
+	}
+
+	squadIds := make([]bson.ObjectId, len(summaries))
+	for index, summary := range summaries {
+		squadIds[index] = summary.ID
+	}
+	counts, err := repository.countSquadMembers(bson.M{"squadId": bson.M{"$in": squadIds}})
 	if err != nil {
 		return nil, err
 	}
+	for index := range summaries {
+		summaries[index].MemberCount = counts[summaries[index].ID]
+	}
+	return summaries, nil
+}
 
-	var allSquadMemberDocuments []SquadMemberDocument
-	repository.loadSquadMemberDocuments(bson.M{}, &allSquadMemberDocuments)
+// findRangedSquadSummaries has Mongo count the members in the range by squad, which leaves out the squads without
+// any, and put those squads in order. Listings by creation only count the members of squads after the cursor.
+func (repository SquadRepository) findRangedSquadSummaries(options SquadListOptions) ([]squadSummary, error) {
+	query := memberRangeQuery(options.Begin, options.End)
+	if options.After != nil && options.Sort == sortByCreation {
+		query["squadId"] = options.afterQuery()["_id"]
+	}
+	pipeline := append(countSquadMembersPipeline(query),
+		// Members are only listed along with a squad that still exists.
+		bson.M{"$lookup": bson.M{
+			"from":         repository.SquadCollection().Name,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "squad",
+		}},
+		bson.M{"$match": bson.M{"squad": bson.M{"$ne": []interface{}{}}}},
+		bson.M{"$project": bson.M{"memberCount": 1}},
+	)
+	if options.After != nil && options.Sort == sortByMemberCount {
+		pipeline = append(pipeline, bson.M{"$match": options.afterQuery()})
+	}
 
-	squadList := []api.Squad{}
-	for _, document := range squadDocuments {
-		squadId := api.SquadId(document.ID)
-		relatedSquadMemberDocuments := filterBySquadId(squadId, allSquadMemberDocuments)
-		squad := buildSquad(squadId, relatedSquadMemberDocuments, begin, end)
+	direction := 1
+	if options.Descending {
+		direction = -1
+	}
+	order := bson.D{{Name: "_id", Value: direction}}
+	if options.Sort == sortByMemberCount {
+		order = bson.D{{Name: "memberCount", Value: direction}, {Name: "_id", Value: direction}}
+	}
+	pipeline = append(pipeline, bson.M{"$sort": order})
+	if options.Limit != 0 {
+		pipeline = append(pipeline, bson.M{"$limit": options.Limit + 1})
+	}
 
-		noRangeRestrictions := begin == nil && end == nil
-		if noRangeRestrictions || len(squad.Members) != 0 {
-			squadList = append(squadList, *squad)
+	var summaries []squadSummary
+	err := repository.SquadMemberCollection().Pipe(pipeline).AllowDiskUse().All(&summaries)
+	return summaries, err
+}
+
+// findSquadSummariesByMemberCount orders every squad by how many members it has. Squads without members are listed
+// too, so the squads are loaded by id alone and put in order along with the counts of their members.
+func (repository SquadRepository) findSquadSummariesByMemberCount(options SquadListOptions) ([]squadSummary, error) {
+	counts, err := repository.countSquadMembers(bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var squads []squadSummary
+	if err := repository.SquadCollection().Find(nil).Select(bson.M{"_id": 1}).All(&squads); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]squadSummary, 0, len(squads))
+	for _, squad := range squads {
+		summary := squadSummary{ID: squad.ID, MemberCount: counts[squad.ID]}
+		if options.After == nil || options.precedes(options.After.summary(), summary) {
+			summaries = append(summaries, summary)
 		}
 	}
+	sort.Slice(summaries, func(first int, second int) bool {
+		return options.precedes(summaries[first], summaries[second])
+	})
+	if options.Limit != 0 && len(summaries) > options.Limit+1 {
+		summaries = summaries[:options.Limit+1]
+	}
+	return summaries, nil
+}
 
-	return squadList, nil
+// countSquadMembers counts the members matching the query by squad, leaving out squads without any.
+func (repository SquadRepository) countSquadMembers(query bson.M) (map[bson.ObjectId]int, error) {
+	var summaries []squadSummary
+	if err := repository.SquadMemberCollection().Pipe(countSquadMembersPipeline(query)).All(&summaries); err != nil {
+		return nil, err
+	}
+	counts := make(map[bson.ObjectId]int, len(summaries))
+	for _, summary := range summaries {
+		counts[summary.ID] = summary.MemberCount
+	}
+	return counts, nil
 }
-func filterBySquadId(squadId api.SquadId, documents []SquadMemberDocument) []SquadMemberDocument {
-	var results []SquadMemberDocument
-	for _, document := range documents {
-		if api.SquadId(document.SquadID) == squadId {
-			results = append(results, document)
-		}
+
+func countSquadMembersPipeline(query bson.M) []bson.M {
+	return []bson.M{
+		{"$match": query},
+		{"$group": bson.M{"_id": "$squadId", "memberCount": bson.M{"$sum": 1}}},
+	}
+}
+
+// squadMemberGroup holds the members of one squad, as grouped by groupSquadMembers.
+type squadMemberGroup struct {
+	SquadID bson.ObjectId         `bson:"_id"`
+	Members []SquadMemberDocument `bson:"members"`
+}
+
+// groupSquadMembers has Mongo pick out the members matching the query and gather them by squad, so that only those
+// members are sent back, with only the selected fields when there is a selection. Squads without any are left out.
+func (repository SquadRepository) groupSquadMembers(query bson.M, selection bson.M) ([]squadMemberGroup, error) {
+	pipeline := []bson.M{
+		{"$match": query},
+		{"$sort": bson.D{{Name: "squadId", Value: 1}, {Name: "_id", Value: 1}}},
+	}
+	if selection != nil {
+		pipeline = append(pipeline, bson.M{"$project": selection})
 	}
-	return results
+	pipeline = append(pipeline,
+		bson.M{"$group": bson.M{"_id": "$squadId", "members": bson.M{"$push": "$$ROOT"}}},
+	)
+	var groups []squadMemberGroup
+	err := repository.SquadMemberCollection().Pipe(pipeline).AllowDiskUse().All(&groups)
+	return groups, err
 }
 
 type SquadDocument struct {
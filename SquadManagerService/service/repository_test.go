@@ -1,10 +1,15 @@
 package service
 
 import (
+	"context"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestRepositoryFactoryWillCopySession(t *testing.T) {
@@ -36,3 +41,223 @@ func TestRepositoryFactoryWillCopySession(t *testing.T) {
 	assert.False(t, repository1.session == factory.parentSession)
 	assert.False(t, repository1.session == repository2.session)
 }
+
+func TestRepositoryFactoryWillNotQueueRequestsBehindAnotherDial(t *testing.T) {
+	// A listener that never answers keeps every dial waiting until it gives up.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	newFactory := func() *SquadRepositoryFactory {
+		return &SquadRepositoryFactory{Config: Configuration{
+			Host:         silent.Addr().String(),
+			DatabaseName: "SquadRepositoryTest",
+			DbTimeout:    50 * time.Millisecond,
+		}}
+	}
+
+	start := time.Now()
+	_, err = newFactory().Repository()
+	assert.IsType(t, &StorageUnavailableError{}, err)
+	oneDial := time.Since(start)
+
+	factory := newFactory()
+	defer factory.Close()
+	start = time.Now()
+	var waiting sync.WaitGroup
+	for range []int{1, 2, 3} {
+		waiting.Add(1)
+		go func() {
+			defer waiting.Done()
+			_, err := factory.Repository()
+			assert.IsType(t, &StorageUnavailableError{}, err)
+		}()
+	}
+	waiting.Wait()
+
+	assert.True(t, time.Since(start) < 2*oneDial, "three dials took %v where one took %v", time.Since(start), oneDial)
+}
+
+func TestWriteWillFinishAndReportOperationsThatOutlastTheirContext(t *testing.T) {
+	factory := &SquadRepositoryFactory{Config: Configuration{
+		Host:         "localhost",
+		DatabaseName: "SquadRepositoryTest",
+		DbTimeout:    time.Second,
+	}}
+	defer factory.Close()
+	repository, err := factory.Repository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	id := bson.NewObjectId()
+	err = repository.write(ctx, func(repository SquadRepository) error {
+		<-ctx.Done()
+		return repository.SquadCollection().Insert(SquadDocument{id})
+	})
+
+	assert.Nil(t, err)
+	count, err := repository.SquadCollection().FindId(id).Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	err = repository.write(ctx, func(repository SquadRepository) error {
+		t.Error("an operation was started after its context was done")
+		return nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+// seedBenchmarkSquads fills a database with a few thousand squads whose members each spend a month or two in them
+// over ten years, so that a range of a month only matches a small part of them.
+func seedBenchmarkSquads(b *testing.B) (*SquadRepository, func()) {
+	factory := &SquadRepositoryFactory{Config: Configuration{
+		Host:         "localhost",
+		DatabaseName: "SquadRepositoryBenchmark",
+		DbTimeout:    time.Second,
+	}}
+	repository, err := factory.Repository()
+	if err != nil {
+		factory.Close()
+		b.Fatal(err)
+	}
+	closeAll := func() {
+		repository.Close()
+		factory.Close()
+	}
+
+	squadList := make([]api.Squad, 2000)
+	for squadIndex := range squadList {
+		squad := api.Squad{ID: api.SquadId(bson.NewObjectId())}
+		for memberIndex := 0; memberIndex < 50; memberIndex++ {
+			begin := api.Date(2010, time.Month(1+(squadIndex+memberIndex*7)%120), 1)
+			squad.Members = append(squad.Members, api.NewSquadMember("member@fake.com", api.Range{
+				Begin: *begin,
+				End:   begin.AddDate(0, 1+memberIndex%2, 0),
+			}))
+		}
+		squadList[squadIndex] = squad
+	}
+	if _, err := repository.overwriteSquadList(context.Background(), squadList); err != nil {
+		closeAll()
+		b.Fatal(err)
+	}
+	return repository, closeAll
+}
+
+// listSquadsInMemory lists squads the way listSquads used to, loading every member and filtering them here.
+func listSquadsInMemory(repository *SquadRepository, begin *time.Time, end *time.Time) ([]api.Squad, error) {
+	squadDocuments, err := repository.findSquadDocuments(bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var allSquadMemberDocuments []SquadMemberDocument
+	if err := repository.SquadMemberCollection().Find(bson.M{}).All(&allSquadMemberDocuments); err != nil {
+		return nil, err
+	}
+
+	squadList := []api.Squad{}
+	for _, document := range squadDocuments {
+		var related []SquadMemberDocument
+		for _, member := range allSquadMemberDocuments {
+			if member.SquadID == document.ID {
+				related = append(related, member)
+			}
+		}
+		members := api.FilterMembers(toApiSquadMemberList(related), begin, end)
+		if (begin == nil && end == nil) || len(members) != 0 {
+			squadList = append(squadList, api.Squad{ID: api.SquadId(document.ID), Members: members})
+		}
+	}
+	return squadList, nil
+}
+
+func BenchmarkListSquadsInRange(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	begin, end := api.Date(2014, 3, 1), api.Date(2014, 4, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := repository.listSquads(context.Background(), SquadListOptions{Begin: begin, End: end}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// listSquadPages lists every squad a page at a time, following the cursors, as the NDJSON stream does.
+func listSquadPages(b *testing.B, repository *SquadRepository, options SquadListOptions) {
+	options.Limit = 100
+	for {
+		_, next, err := repository.listSquads(context.Background(), options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if next == nil {
+			return
+		}
+		options.After = next
+	}
+}
+
+func BenchmarkListSquadPagesInRange(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	begin, end := api.Date(2014, 3, 1), api.Date(2014, 4, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		listSquadPages(b, repository, SquadListOptions{Sort: sortByCreation, Begin: begin, End: end})
+	}
+}
+
+func BenchmarkListSquadPagesByMemberCount(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		listSquadPages(b, repository, SquadListOptions{Sort: sortByMemberCount})
+	}
+}
+
+func BenchmarkListSquadsInRangeInMemory(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	begin, end := api.Date(2014, 3, 1), api.Date(2014, 4, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := listSquadsInMemory(repository, begin, end); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListAllSquads(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := repository.listSquads(context.Background(), SquadListOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListAllSquadsInMemory(b *testing.B) {
+	repository, closeAll := seedBenchmarkSquads(b)
+	defer closeAll()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := listSquadsInMemory(repository, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
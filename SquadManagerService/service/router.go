@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Route is a registered method and path. Public routes are open to anonymous callers.
+type Route struct {
+	Method string
+	Path   string
+	Public bool
+}
+
+// routeRegistry registers handles on the router while remembering each route, and tags every request it serves with
+// the template of the matched route so that it can be reported without the raw URL. Routes registered with handle
+// require an authenticated caller holding the given permission, and count against the caller's rate limit, after
+// counting against the looser limit of the address they are called from. Public routes are not rate limited. Every
+// route reads at most the configured size of request body.
+type routeRegistry struct {
+	router  *httprouter.Router
+	context *Context
+	routes  []Route
+}
+
+func (registry *routeRegistry) handle(method string, path string, allowed permission, handle httprouter.Handle) {
+	service := registry.context
+	limited := rateLimit(service.RateLimiter, clientIdentity, handle)
+	authorized := rateLimit(service.AddressRateLimiter, clientAddress, service.authorize(allowed, limited))
+	registry.register(Route{method, path, false}, authorized)
+}
+
+func (registry *routeRegistry) public(method string, path string, handle httprouter.Handle) {
+	registry.register(Route{method, path, true}, handle)
+}
+
+func (registry *routeRegistry) register(route Route, handle httprouter.Handle) {
+	method, path := route.Method, route.Path
+	registry.routes = append(registry.routes, route)
+	maxBodyBytes := int64(registry.context.Limits.MaxBodyBytes)
+	registry.router.Handle(method, path, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if route, ok := request.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+			route.template = path
+		}
+		if request.Body != nil {
+			request.Body = http.MaxBytesReader(writer, request.Body, maxBodyBytes)
+		}
+		handle(writer, request, params)
+	})
+}
+
+type matchedRouteKey struct{}
+
+type matchedRoute struct {
+	template string
+}
+
+func (route *matchedRoute) name() string {
+	if route.template == "" {
+		return "unmatched"
+	}
+	return route.template
+}
+
+// routeTemplate reports the template of the route serving the request, once it has been matched.
+func routeTemplate(request *http.Request) string {
+	if route, ok := request.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		return route.name()
+	}
+	return "unmatched"
+}
+
+func withRouteTemplate(request *http.Request) (*http.Request, *matchedRoute) {
+	if route, ok := request.Context().Value(matchedRouteKey{}).(*matchedRoute); ok {
+		return request, route
+	}
+	route := &matchedRoute{}
+	return request.WithContext(context.WithValue(request.Context(), matchedRouteKey{}, route)), route
+}
@@ -1,7 +1,10 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"time"
@@ -10,39 +13,127 @@ import (
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 )
 
-func listSquads(request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
-	squadParameters, err := parseSquadParameters(request)
-	squads, err := repository.listSquads(squadParameters.begin, squadParameters.end)
-	return ResponseEntity{squads, http.StatusOK}, err
+func listSquads(ctx context.Context, request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	options, err := parseSquadListOptions(request)
+	if err != nil {
+		return ResponseEntity{err, http.StatusBadRequest}, nil
+	}
+
+	squads, next, err := repository.listSquads(ctx, options)
+	if err != nil {
+		return ResponseEntity{}, err
+	}
+	body := options.Projection.renderSquads(squads)
+	if next == nil {
+		return ResponseEntity{body, http.StatusOK}, nil
+	}
+	return ResponseEntity{headed{body, http.Header{"Link": {nextPageLink(request, *next)}}}, http.StatusOK}, nil
 }
 
-func overwriteSquadList(request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+// streamBatchSize is how many squads a streamed listing loads at a time.
+const streamBatchSize = 100
+
+// streamSquads writes a listing one squad per line, loading it a page at a time so that memory stays flat however
+// many squads there are. Each page gets the request timeout of its own. Once the first line is out, a failure can only
+// cut the stream short.
+func streamSquads(writer http.ResponseWriter, request *http.Request, _ httprouter.Params, service *Context) {
+	fail := func(err error) {
+		service.Logger.forRequest(request).Error("request failed",
+			"method", request.Method,
+			"route", routeTemplate(request),
+			"error", err,
+		)
+	}
+
+	options, err := parseSquadListOptions(request)
+	if err != nil {
+		respond(writer, request, ResponseEntity{err, http.StatusBadRequest})
+		return
+	}
+	if options.Limit != 0 {
+		respond(writer, request, ResponseEntity{errors.New("streamed listings are not paged; leave out limit"), http.StatusBadRequest})
+		return
+	}
+	options.Limit = streamBatchSize
+
+	ctx, cancel := withRequestTimeout(request.Context(), service.RequestTimeout)
+	repository, err := service.repository(ctx)
+	cancel()
+	if unknown, ok := err.(*UnknownTenantError); ok {
+		respond(writer, request, ResponseEntity{unknown, http.StatusNotFound})
+		return
+	} else if err != nil {
+		fail(err)
+		respond(writer, request, ResponseEntity{code: errorStatus(err)})
+		return
+	}
+	defer repository.Close()
+
+	flusher, _ := writer.(http.Flusher)
+	encoder := json.NewEncoder(writer)
+	for started := false; ; started = true {
+		ctx, cancel := withRequestTimeout(request.Context(), service.RequestTimeout)
+		squads, next, err := repository.listSquads(ctx, options)
+		cancel()
+		if err != nil {
+			fail(err)
+			if !started {
+				respond(writer, request, ResponseEntity{code: errorStatus(err)})
+			}
+			return
+		}
+
+		if !started {
+			writer.Header().Set("Content-Type", ndjsonContentType)
+			writer.WriteHeader(http.StatusOK)
+		}
+		for _, squad := range squads {
+			if err := encoder.Encode(options.Projection.renderSquad(squad)); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if next == nil {
+			return
+		}
+		options.After = next
+	}
+}
+
+func overwriteSquadList(ctx context.Context, request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
 	squadList := []api.Squad{}
-	if err := json.NewDecoder(request.Body).Decode(&squadList); err != nil {
-		return ResponseEntity{err, http.StatusBadRequest}, nil
+	if rejected := decodeBody(request, &squadList); rejected != nil {
+		return *rejected, nil
+	}
+	if squadList == nil {
+		squadList = []api.Squad{}
 	}
 
-	squads, err := repository.overwriteSquadList(squadList)
+	squads, err := repository.overwriteSquadList(ctx, squadList)
 	return ResponseEntity{squads, http.StatusOK}, err
 }
 
-func createSquad(repository *SquadRepository) (ResponseEntity, error) {
-	squad, err := repository.addSquad()
+func createSquad(ctx context.Context, repository *SquadRepository) (ResponseEntity, error) {
+	squad, err := repository.addSquad(ctx)
 	return ResponseEntity{squad, http.StatusAccepted}, err
 }
 
 type SquadParameters struct {
-	begin *time.Time
-	end   *time.Time
+	begin      *time.Time
+	end        *time.Time
+	projection SquadProjection
 }
 
-func getSquad(request *http.Request, repository *SquadRepository, squadId string) (ResponseEntity, error) {
+func getSquad(ctx context.Context, request *http.Request, repository *SquadRepository, squadId string) (ResponseEntity, error) {
 	parameters, err := parseSquadParameters(request)
 	if err != nil {
 		return ResponseEntity{err, http.StatusBadRequest}, nil
 	}
 
-	squad, err := repository.getSquad(squadId, parameters.begin, parameters.end)
+	squad, err := repository.getSquad(ctx, squadId, parameters.begin, parameters.end, parameters.projection)
 	if err != nil {
 		return ResponseEntity{}, err
 	}
@@ -51,7 +142,7 @@ func getSquad(request *http.Request, repository *SquadRepository, squadId string
 		return ResponseEntity{code: http.StatusNotFound}, nil
 	}
 
-	return ResponseEntity{squad, http.StatusOK}, nil
+	return ResponseEntity{parameters.projection.renderSquad(*squad), http.StatusOK}, nil
 }
 
 func parseSquadParameters(request *http.Request) (SquadParameters, error) {
@@ -61,20 +152,51 @@ func parseSquadParameters(request *http.Request) (SquadParameters, error) {
 		return SquadParameters{}, err
 	}
 	endDate, err := api.ParseDate(values.Get("end"))
+	if err != nil {
+		return SquadParameters{}, err
+	}
+	projection, err := parseSquadProjection(request)
 
-	return SquadParameters{beginDate, endDate}, err
+	return SquadParameters{beginDate, endDate, projection}, err
 }
 
-func postSquadMember(request *http.Request, repository *SquadRepository, squadId string) (ResponseEntity, error) {
+func postSquadMember(ctx context.Context, request *http.Request, repository *SquadRepository, squadId string) (ResponseEntity, error) {
 	var squadMember api.SquadMember
-	if err := json.NewDecoder(request.Body).Decode(&squadMember); err != nil {
-		return ResponseEntity{err, http.StatusBadRequest}, nil
+	if rejected := decodeBody(request, &squadMember); rejected != nil {
+		return *rejected, nil
+	}
+
+	if squad, err := repository.getSquad(ctx, squadId, nil, nil, SquadProjection{OmitMembers: true}); err != nil || squad == nil {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+
+	identity := IdentityFrom(request.Context())
+	mayMove := func(fromSquadId string) bool {
+		return identity == nil || mayEditSquad(identity, fromSquadId)
+	}
+	posted, err := repository.postSquadMember(ctx, squadMember, squadId, mayMove)
+	if err != nil {
+		return ResponseEntity{}, err
+	}
+	if !posted {
+		err := fmt.Errorf("member %s belongs to another squad you may not edit", squadMember.ID)
+		return ResponseEntity{err, http.StatusConflict}, nil
 	}
+	return ResponseEntity{squadMember.ID, http.StatusAccepted}, nil
+}
 
-	if squad, err := repository.getSquad(squadId, nil, nil); err != nil || squad == nil {
+func deleteSquad(ctx context.Context, _ *http.Request, repository *SquadRepository, squadId string) (ResponseEntity, error) {
+	deleted, err := repository.deleteSquad(ctx, squadId)
+	if err != nil || !deleted {
 		return ResponseEntity{code: http.StatusNotFound}, err
 	}
+	return ResponseEntity{code: http.StatusNoContent}, nil
+}
 
-	err := repository.postSquadMember(squadMember, squadId)
-	return ResponseEntity{squadMember.ID, http.StatusAccepted}, err
+func deleteSquadMember(ctx context.Context, _ *http.Request, params httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	deleted, err := repository.deleteSquadMember(ctx, params.ByName("id"), params.ByName("memberId"))
+	if err != nil || !deleted {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+	return ResponseEntity{code: http.StatusNoContent}, nil
 }
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServeUntilSignalled serves the handler on the listener until a signal arrives. It then stops accepting new
+// connections, ends open event streams, gives in-flight requests up to the shutdown timeout to finish and finally
+// closes the main handler, releasing its database sessions.
+func ServeUntilSignalled(
+	listener net.Listener,
+	handler http.Handler,
+	mainHandler *MainHandler,
+	shutdownTimeout time.Duration,
+	signals <-chan os.Signal,
+) error {
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(mainHandler.context.Events.Close)
+	defer mainHandler.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case received := <-signals:
+		mainHandler.Logger().Info("draining connections", "signal", received, "timeout", shutdownTimeout)
+	}
+
+	deadline, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(deadline); err != nil {
+		server.Close()
+		return err
+	}
+
+	if err := <-served; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
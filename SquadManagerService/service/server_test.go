@@ -0,0 +1,105 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShutdownTestHandler() *MainHandler {
+	return MakeMainHandler(Configuration{Host: "0.0.0.0", DatabaseName: "ShutdownTest", DbTimeout: time.Second / 100})
+}
+
+func TestServeUntilSignalledWillFinishInFlightRequestsBeforeReturning(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	release := make(chan bool)
+	slowHandler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started <- true
+		<-release
+		writer.WriteHeader(http.StatusTeapot)
+	})
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUntilSignalled(listener, slowHandler, newShutdownTestHandler(), time.Second, signals)
+	}()
+
+	responses := make(chan int, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- 0
+			return
+		}
+		response.Body.Close()
+		responses <- response.StatusCode
+	}()
+	<-started
+	signals <- syscall.SIGTERM
+
+	select {
+	case <-served:
+		t.Fatal("Server stopped before the in-flight request finished.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err)
+
+	close(release)
+	assert.Equal(t, http.StatusTeapot, <-responses)
+	assert.NoError(t, <-served)
+}
+
+func TestServeUntilSignalledWillGiveUpOnRequestsAfterShutdownTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	stuckHandler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started <- true
+		<-request.Context().Done()
+	})
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUntilSignalled(listener, stuckHandler, newShutdownTestHandler(), 10*time.Millisecond, signals)
+	}()
+
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+	signals <- syscall.SIGINT
+
+	assert.Error(t, <-served)
+}
+
+func TestServeUntilSignalledWillEndEventStreams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainHandler := newShutdownTestHandler()
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUntilSignalled(listener, mainHandler, mainHandler, time.Second, signals)
+	}()
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	signals <- syscall.SIGTERM
+
+	assert.NoError(t, <-served)
+}
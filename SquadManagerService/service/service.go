@@ -4,24 +4,51 @@ import (
 	"net/http"
 	"time"
 
-	"log"
-
 	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 )
 
 type Configuration struct {
-	DatabaseName string
-	Host         string
-	DbTimeout    time.Duration
+	ListenAddress   string
+	ShutdownTimeout time.Duration
+	RequestTimeout  time.Duration
+	DatabaseName    string
+	Host            string
+	DbTimeout       time.Duration
+	SkipIndexes     bool
+	Logging         LoggingConfiguration
+	Auth            AuthConfiguration
+	Tenancy         TenancyConfiguration
+	Cors            CorsConfiguration
+	Limits          LimitsConfiguration
+	Health          HealthConfiguration
+	Webhooks        WebhookConfiguration
+	Events          EventConfiguration
+	Cache           CacheConfiguration
+	Compression     CompressionConfiguration
 }
 
 type MainHandler struct {
 	context *Context
 	router  *httprouter.Router
+	routes  []Route
+	handler http.HandlerFunc
 }
 
 func (mainHandler *MainHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	LogRequest(mainHandler.router.ServeHTTP)(writer, request)
+	mainHandler.handler(writer, request)
+}
+
+func (mainHandler *MainHandler) Logger() *Logger {
+	return mainHandler.context.Logger
+}
+
+func (mainHandler *MainHandler) Routes() []Route {
+	return mainHandler.routes
+}
+
+func (mainHandler *MainHandler) CheckDependencies() api.Health {
+	return mainHandler.context.CheckDependencies()
 }
 
 func (mainHandler *MainHandler) Close() {
@@ -35,14 +62,48 @@ func MakeMainHandler(config Configuration) *MainHandler {
 	}
 
 	router := httprouter.New()
+	routes := &routeRegistry{router: router, context: context}
+
+	routes.public("GET", "/healthz", context.with(ThinHandler(checkLiveness)))
+	routes.public("GET", "/readyz", context.with(ServiceHandler(checkReadiness)))
+	routes.public("GET", "/openapi.json", context.with(serveOpenAPI(config, routes)))
+
+	routes.handle("GET", "/squad", canView, context.with(NegotiatedHandler{Json: CachedHandler{Handler(listSquads)}, Ndjson: RawHandler(streamSquads)}))
+	routes.handle("PUT", "/squad", isAdmin, context.with(Handler(overwriteSquadList)))
+	routes.handle("POST", "/squad", isAdmin, context.with(NoInputHandler(createSquad)))
+	routes.handle("GET", "/squad/:id", canView, context.with(SquadHandler(getSquad)))
+	routes.handle("POST", "/squad/:id", canLeadSquad, context.with(SquadHandler(postSquadMember)))
+	routes.handle("DELETE", "/squad/:id", isAdmin, context.with(SquadHandler(deleteSquad)))
+	routes.handle("DELETE", "/squad/:id/member/:memberId", canLeadSquad, context.with(Handler(deleteSquadMember)))
+
+	routes.public("GET", "/metrics", context.with(RawHandler(serveMetrics)))
+	routes.handle("GET", "/events", canView, context.with(RawHandler(streamEvents)))
+
+	routes.handle("GET", "/webhook", isAdmin, context.with(Handler(listWebhooks)))
+	routes.handle("POST", "/webhook", isAdmin, context.with(Handler(createWebhook)))
+	routes.handle("GET", "/webhook/:id", isAdmin, context.with(WebhookHandler(getWebhook)))
+	routes.handle("PUT", "/webhook/:id", isAdmin, context.with(WebhookHandler(updateWebhook)))
+	routes.handle("DELETE", "/webhook/:id", isAdmin, context.with(WebhookHandler(deleteWebhook)))
+	routes.handle("GET", "/webhook/:id/delivery", isAdmin, context.with(WebhookHandler(listWebhookDeliveries)))
+
+	if config.Tenancy.enabled() {
+		routes.handle("GET", "/tenant", isOperator, context.with(ControlHandler(listTenants)))
+		routes.handle("POST", "/tenant", isOperator, context.with(ControlHandler(createTenant)))
+		routes.handle("DELETE", "/tenant/:tenant", isOperator, context.forgetTenant(context.with(ControlHandler(deleteTenant))))
+	}
+
+	routes.handle("GET", "/apikey", isAdmin, context.with(Handler(listApiKeys)))
+	routes.handle("POST", "/apikey", isAdmin, context.with(Handler(createApiKey)))
+	routes.handle("DELETE", "/apikey/:id", isAdmin, context.with(Handler(revokeApiKey)))
 
-	router.GET("/squad", context.with(Handler(listSquads)))
-	router.PUT("/squad", context.with(Handler(overwriteSquadList)))
-	router.POST("/squad", context.with(NoInputHandler(createSquad)))
-	router.GET("/squad/:id", context.with(SquadHandler(getSquad)))
-	router.POST("/squad/:id", context.with(SquadHandler(postSquadMember)))
+	handler := WithRequestId(
+		LogRequest(context.Logger,
+			Cors(config.Cors,
+				Compress(config.Compression,
+					context.Metrics.Instrument(
+						context.resolveTenant(router.ServeHTTP))))))
 
-	return &MainHandler{context, router}
+	return &MainHandler{context, router, routes.routes, handler}
 }
 
 type loggingResponseWriter struct {
@@ -55,12 +116,8 @@ func (writer *loggingResponseWriter) WriteHeader(code int) {
 	writer.ResponseWriter.WriteHeader(code)
 }
 
-func LogRequest(next http.HandlerFunc) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		start := time.Now()
-		loggingWriter := &loggingResponseWriter{ResponseWriter: writer, statusCode: http.StatusOK}
-		next(loggingWriter, request)
-		duration := time.Now().Sub(start)
-		log.Println(loggingWriter.statusCode, duration, request.Method, request.URL.String())
+func (writer *loggingResponseWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
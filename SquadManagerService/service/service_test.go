@@ -1,9 +1,13 @@
 package service_test
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,6 +15,7 @@ import (
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/service"
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/testutil"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -48,7 +53,21 @@ func TestWillErrorWhenDatasourceNotAvailable(t *testing.T) {
 	tester := testutil.New(t, handler)
 
 	tester.GetSquadList(nil, nil).
-		CheckStatus(http.StatusInternalServerError)
+		CheckStatus(http.StatusServiceUnavailable)
+}
+
+func TestWillTimeOutWhenStorageExceedsRequestTimeout(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName:   "SquadManagerTestDB",
+		Host:           "localhost",
+		DbTimeout:      time.Second,
+		RequestTimeout: time.Nanosecond,
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+
+	tester.GetSquadList(nil, nil).
+		CheckStatus(http.StatusGatewayTimeout)
 }
 
 func TestPOSTSquadWillIncludeNewSquadInSubsequentGET(t *testing.T) {
@@ -193,6 +212,168 @@ func TestPOSTSquadMembersWillShowSquadMembersInSubsequentGETSquadList(t *testing
 	assert.Contains(t, squadList, api.Squad{ID: newSquadId, Members: members})
 }
 
+func squadsWithMemberCounts(counts ...int) []api.Squad {
+	squads := make([]api.Squad, len(counts))
+	for index, count := range counts {
+		squads[index] = api.Squad{ID: api.SquadId(bson.NewObjectId()), Members: []api.SquadMember{}}
+		for member := 0; member < count; member++ {
+			squads[index].Members = append(squads[index].Members, api.NewSquadMember("dale@fake.com", api.Range{
+				Begin: *api.Date(2017, 7, 30),
+				End:   *api.Date(2017, 11, 10),
+			}))
+		}
+	}
+	return squads
+}
+
+func TestGETSquadListWillPageThroughEverySquad(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadList := squadsWithMemberCounts(2, 0, 1, 3, 1)
+	tester.PerformPutSquadList(squadList)
+
+	pages := tester.PerformGetSquadPages(url.Values{"limit": {"2"}})
+
+	assert.Equal(t, [][]api.Squad{squadList[0:2], squadList[2:4], squadList[4:5]}, pages)
+}
+
+func TestGETSquadListCanBeSortedByMemberCount(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadList := squadsWithMemberCounts(2, 0, 1, 3, 1)
+	tester.PerformPutSquadList(squadList)
+
+	pages := tester.PerformGetSquadPages(url.Values{"limit": {"3"}, "sort": {"-members"}})
+
+	expected := [][]api.Squad{
+		{squadList[3], squadList[0], squadList[4]},
+		{squadList[2], squadList[1]},
+	}
+	assert.Equal(t, expected, pages)
+}
+
+func TestGETSquadListCanLeaveOutMembers(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadList := squadsWithMemberCounts(2, 1)
+	tester.PerformPutSquadList(squadList)
+
+	pages := tester.PerformGetSquadPages(url.Values{"members": {"false"}})
+
+	assert.Equal(t, [][]api.Squad{{{ID: squadList[0].ID}, {ID: squadList[1].ID}}}, pages)
+}
+
+func TestGETSquadCanCountMembersWithoutLoadingThem(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadList := squadsWithMemberCounts(3)
+	tester.PerformPutSquadList(squadList)
+	values := &url.Values{"include": {"memberCount"}}
+
+	var squad map[string]interface{}
+	tester.GetSquadWithParameters(squadList[0].ID, values).
+		CheckStatus(http.StatusOK).
+		LoadJson(&squad)
+
+	assert.Equal(t, map[string]interface{}{"ID": squadList[0].ID.String(), "MemberCount": 3.0}, squad)
+}
+
+func TestGETSquadListCanSelectMemberFields(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadList := squadsWithMemberCounts(1)
+	tester.PerformPutSquadList(squadList)
+
+	var squads []map[string]interface{}
+	tester.DoRequest("GET", "/squad?include=members,memberCount&fields=Email", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&squads)
+
+	expected := []map[string]interface{}{{
+		"ID":          squadList[0].ID.String(),
+		"Members":     []interface{}{map[string]interface{}{"Email": "dale@fake.com"}},
+		"MemberCount": 1.0,
+	}}
+	assert.Equal(t, expected, squads)
+}
+
+func TestGETSquadListCanStreamEverySquadAsNdjson(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	counts := make([]int, 250)
+	for index := range counts {
+		counts[index] = index % 3
+	}
+	squadList := squadsWithMemberCounts(counts...)
+	tester.PerformPutSquadList(squadList)
+	request := httptest.NewRequest("GET", "/squad?members=false", nil)
+	request.Header.Set("Accept", "application/x-ndjson")
+	recorder := httptest.NewRecorder()
+
+	mainHandler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	assert.Len(t, lines, len(squadList))
+	for index, line := range lines {
+		assert.JSONEq(t, `{"ID":"`+squadList[index].ID.String()+`"}`, line)
+	}
+}
+
+func TestGETSquadListWillBeCachedUntilTheSquadsChange(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "SquadManagerTestDB",
+		Host:         "localhost",
+		DbTimeout:    time.Second,
+		Cache:        service.CacheConfiguration{Enabled: true},
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+	squadId := tester.PerformPostSquad()
+	begin := api.Date(2017, 8, 1)
+
+	assert.Equal(t, "MISS", tester.GetSquadList(begin, nil).Recorder.Header().Get("X-Cache"))
+	cached := tester.GetSquadList(begin, nil).CheckStatus(http.StatusOK)
+	assert.Equal(t, "HIT", cached.Recorder.Header().Get("X-Cache"))
+	assert.Equal(t, "private, no-cache", cached.Recorder.Header().Get("Cache-Control"))
+
+	member := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 11, 10)})
+	tester.PerformPostSquadMember(squadId, member)
+
+	changed := tester.GetSquadList(begin, nil)
+	assert.Equal(t, "MISS", changed.Recorder.Header().Get("X-Cache"))
+	var squads []api.Squad
+	changed.LoadJson(&squads)
+	assert.Contains(t, squads, api.Squad{ID: squadId, Members: []api.SquadMember{member}})
+}
+
+func TestGETSquadListWillBeCompressedForCallersThatAcceptIt(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "SquadManagerTestDB",
+		Host:         "localhost",
+		DbTimeout:    time.Second,
+		Compression:  service.CompressionConfiguration{Enabled: true},
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+	tester.PerformPutSquadList(squadsWithMemberCounts(20, 20, 20))
+	plain := tester.GetSquadList(nil, nil).CheckStatus(http.StatusOK)
+
+	for _, encoding := range []string{"gzip", "br"} {
+		compressed := tester.WithHeader("Accept-Encoding", encoding).GetSquadList(nil, nil).
+			CheckStatus(http.StatusOK)
+
+		assert.Equal(t, encoding, compressed.Recorder.Header().Get("Content-Encoding"))
+		assert.Contains(t, compressed.Recorder.Header()["Vary"], "Accept-Encoding")
+		assert.True(t, compressed.Recorder.Body.Len() < plain.Recorder.Body.Len())
+		assert.Equal(t, plain.Body(), compressed.Body())
+	}
+}
+
+func TestGETSquadListWithInvalidParametersWillError(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+
+	for _, query := range []string{"sort=name", "limit=0", "cursor=elsewhere", "begin=tomorrow", "include=person", "fields=Age"} {
+		tester.DoRequest("GET", "/squad?"+query, nil).
+			CheckStatus(http.StatusBadRequest)
+	}
+}
+
 func TestPOSTSquadMemberMultipleTimesWillUpdate(t *testing.T) {
 	tester := testutil.New(t, mainHandler)
 	squadId := tester.PerformPostSquad()
@@ -314,3 +495,444 @@ func TestPOSTSquadMemberWill404WhenSquadDoesNotExist(t *testing.T) {
 	tester.PostSquadMember(squadId, member).
 		CheckStatus(http.StatusNotFound)
 }
+
+func TestPOSTWebhookWillIncludeWebhookInSubsequentGETWithoutSecret(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+
+	webhook := tester.PerformPostWebhook(api.Webhook{
+		URL:    "http://localhost:9999/hook",
+		Events: []api.EventType{api.SquadCreated},
+	})
+
+	assert.NotEmpty(t, webhook.Secret)
+	webhook.Secret = ""
+	assert.Contains(t, tester.PerformGetWebhookList(), webhook)
+}
+
+func TestPOSTWebhookWithInvalidURLWillError(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+
+	tester.PostWebhook(api.Webhook{URL: "not a url"}).
+		CheckStatus(http.StatusBadRequest)
+}
+
+func TestPOSTWebhookWithUnknownEventTypeWillError(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+
+	tester.PostWebhook(api.Webhook{URL: "http://localhost:9999/hook", Events: []api.EventType{"squad-exploded"}}).
+		CheckStatus(http.StatusBadRequest)
+}
+
+func TestDELETEWebhookWillRemoveWebhook(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	webhook := tester.PerformPostWebhook(api.Webhook{URL: "http://localhost:9999/hook"})
+
+	tester.DeleteWebhook(webhook.ID).
+		CheckStatus(http.StatusNoContent)
+	tester.GetWebhook(webhook.ID).
+		CheckStatus(http.StatusNotFound)
+}
+
+func TestWebhookWillReceiveSignedEventWhenSquadIsCreated(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	events := make(chan api.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var event api.Event
+		json.NewDecoder(request.Body).Decode(&event)
+		events <- event
+	}))
+	defer receiver.Close()
+	webhook := tester.PerformPostWebhook(api.Webhook{URL: receiver.URL, Events: []api.EventType{api.SquadCreated}})
+	defer tester.DeleteWebhook(webhook.ID)
+
+	squadId := tester.PerformPostSquad()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, api.SquadCreated, event.Type)
+		assert.Equal(t, squadId, *event.SquadID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event was received.")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tester.PerformGetWebhookDeliveries(webhook.ID)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	deliveries := tester.PerformGetWebhookDeliveries(webhook.ID)
+	if assert.Equal(t, 1, len(deliveries)) {
+		assert.True(t, deliveries[0].Success)
+	}
+}
+
+func TestDELETESquadMemberWillRemoveMemberFromSubsequentGETSquad(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadId := tester.PerformPostSquad()
+	dale := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 11, 10)})
+	chip := api.NewSquadMember("chip@fake.com", api.Range{Begin: *api.Date(2017, 5, 1), End: *api.Date(2017, 9, 15)})
+	tester.PerformPostSquadMember(squadId, dale)
+	tester.PerformPostSquadMember(squadId, chip)
+
+	tester.DeleteSquadMember(squadId, dale.ID).
+		CheckStatus(http.StatusNoContent)
+	squad := tester.PerformGetSquad(squadId, nil, nil)
+
+	assert.Equal(t, []api.SquadMember{chip}, squad.Members)
+}
+
+func TestDELETESquadMemberFromAnotherSquadWillReturn404(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadId := tester.PerformPostSquad()
+	otherSquadId := tester.PerformPostSquad()
+	dale := api.NewSquadMember("dale@fake.com", api.Range{Begin: *api.Date(2017, 7, 30), End: *api.Date(2017, 11, 10)})
+	tester.PerformPostSquadMember(squadId, dale)
+
+	tester.DeleteSquadMember(otherSquadId, dale.ID).
+		CheckStatus(http.StatusNotFound)
+}
+
+func TestHealthzWillRespondOkEvenWhenDatasourceNotAvailable(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "SquadManagerTestDB",
+		Host:         "missing",
+		DbTimeout:    time.Millisecond / 100,
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+
+	var health api.Health
+	tester.DoRequest("GET", "/healthz", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&health)
+
+	assert.Equal(t, api.StatusOK, health.Status)
+}
+
+func TestReadyzWillReportDatabaseDownWhenDatasourceNotAvailable(t *testing.T) {
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "SquadManagerTestDB",
+		Host:         "missing",
+		DbTimeout:    time.Millisecond / 100,
+		Health:       service.HealthConfiguration{ReadinessTimeout: time.Second},
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+
+	var health api.Health
+	tester.DoRequest("GET", "/readyz", nil).
+		CheckStatus(http.StatusServiceUnavailable).
+		LoadJson(&health)
+
+	assert.Equal(t, api.StatusUnavailable, health.Status)
+	assert.Equal(t, api.StatusDown, health.Dependencies["database"].Status)
+	assert.NotEmpty(t, health.Dependencies["database"].Error)
+}
+
+func TestReadyzWillReportDatabaseUp(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+
+	var health api.Health
+	tester.DoRequest("GET", "/readyz", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&health)
+
+	assert.Equal(t, api.StatusOK, health.Status)
+	assert.Equal(t, api.StatusUp, health.Dependencies["database"].Status)
+}
+
+var authConfig = service.Configuration{
+	DatabaseName: "SquadManagerTestDB",
+	Host:         "localhost",
+	DbTimeout:    time.Second,
+	Auth:         service.AuthConfiguration{Enabled: true, AdminKey: "bootstrap-admin-key"},
+}
+
+func TestAuthWillRejectRequestsWithoutAnApiKey(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+
+	response := tester.GetSquadList(nil, nil).
+		CheckStatus(http.StatusUnauthorized)
+
+	assert.Equal(t, `Bearer realm="squadmanager"`, response.Recorder.Header().Get("WWW-Authenticate"))
+	tester.DoRequest("GET", "/healthz", nil).
+		CheckStatus(http.StatusOK)
+}
+
+func TestApiKeyCreatedByAdminWillAuthenticateUntilRevoked(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	admin := testutil.New(t, handler).WithApiKey("bootstrap-admin-key")
+
+	apiKey := admin.PerformPostApiKey(api.ApiKey{Name: "reporting"})
+	assert.NotEmpty(t, apiKey.Key)
+	user := testutil.New(t, handler).WithApiKey(apiKey.Key)
+
+	user.GetSquadList(nil, nil).
+		CheckStatus(http.StatusOK)
+	user.PostApiKey(api.ApiKey{Name: "escalation"}).
+		CheckStatus(http.StatusForbidden)
+
+	for _, listed := range admin.PerformGetApiKeyList() {
+		assert.Empty(t, listed.Key)
+	}
+
+	admin.RevokeApiKey(apiKey.ID).
+		CheckStatus(http.StatusNoContent)
+	user.GetSquadList(nil, nil).
+		CheckStatus(http.StatusUnauthorized)
+	admin.RevokeApiKey(apiKey.ID).
+		CheckStatus(http.StatusNotFound)
+}
+
+func TestPOSTApiKeyWithoutNameWillError(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	admin := testutil.New(t, handler).WithApiKey("bootstrap-admin-key")
+
+	admin.PostApiKey(api.ApiKey{Name: " "}).
+		CheckStatus(http.StatusBadRequest)
+}
+
+func TestPOSTApiKeyWithInvalidRoleOrSquadsWillError(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	admin := testutil.New(t, handler).WithApiKey("bootstrap-admin-key")
+	squadId := api.SquadId(bson.NewObjectId())
+
+	admin.PostApiKey(api.ApiKey{Name: "owner", Role: "owner"}).
+		CheckStatus(http.StatusBadRequest)
+	admin.PostApiKey(api.ApiKey{Name: "lead", Role: api.RoleSquadLead}).
+		CheckStatus(http.StatusBadRequest)
+	admin.PostApiKey(api.ApiKey{Name: "viewer", Role: api.RoleViewer, Squads: []api.SquadId{squadId}}).
+		CheckStatus(http.StatusBadRequest)
+}
+
+func TestSquadLeadKeyWillOnlyEditMembersOfItsOwnSquads(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	admin := testutil.New(t, handler).WithApiKey("bootstrap-admin-key")
+	ledSquadId := admin.PerformPostSquad()
+	otherSquadId := admin.PerformPostSquad()
+
+	apiKey := admin.PerformPostApiKey(api.ApiKey{Name: "lead", Role: api.RoleSquadLead, Squads: []api.SquadId{ledSquadId}})
+	assert.Equal(t, []api.SquadId{ledSquadId}, apiKey.Squads)
+	lead := testutil.New(t, handler).WithApiKey(apiKey.Key)
+	member := api.NewSquadMember("dale@example.com", api.Range{})
+
+	lead.PostSquadMember(ledSquadId, member).
+		CheckStatus(http.StatusAccepted)
+	lead.DeleteSquadMember(ledSquadId, member.ID).
+		CheckStatus(http.StatusNoContent)
+	lead.PostSquadMember(otherSquadId, member).
+		CheckStatus(http.StatusForbidden)
+	lead.GetSquad(otherSquadId, nil, nil).
+		CheckStatus(http.StatusOK)
+	lead.DeleteSquad(ledSquadId).
+		CheckStatus(http.StatusForbidden)
+
+	otherMember := api.NewSquadMember("chip@example.com", api.Range{})
+	admin.PerformPostSquadMember(otherSquadId, otherMember)
+	lead.PostSquadMember(ledSquadId, otherMember).
+		CheckStatus(http.StatusConflict)
+	assert.Equal(t, []api.SquadMember{otherMember}, admin.PerformGetSquad(otherSquadId, nil, nil).Members)
+}
+
+func TestPOSTSquadMemberWillMoveMembersFromSquadsTheCallerMayEdit(t *testing.T) {
+	handler := service.MakeMainHandler(authConfig)
+	defer handler.Close()
+	admin := testutil.New(t, handler).WithApiKey("bootstrap-admin-key")
+	firstSquadId := admin.PerformPostSquad()
+	secondSquadId := admin.PerformPostSquad()
+	member := api.NewSquadMember("dale@example.com", api.Range{})
+	admin.PerformPostSquadMember(firstSquadId, member)
+
+	admin.PostSquadMember(secondSquadId, member).
+		CheckStatus(http.StatusAccepted)
+
+	assert.Empty(t, admin.PerformGetSquad(firstSquadId, nil, nil).Members)
+	assert.Equal(t, []api.SquadMember{member}, admin.PerformGetSquad(secondSquadId, nil, nil).Members)
+
+	apiKey := admin.PerformPostApiKey(api.ApiKey{Name: "lead", Role: api.RoleSquadLead, Squads: []api.SquadId{firstSquadId, secondSquadId}})
+	lead := testutil.New(t, handler).WithApiKey(apiKey.Key)
+
+	lead.PostSquadMember(firstSquadId, member).
+		CheckStatus(http.StatusAccepted)
+
+	assert.Equal(t, []api.SquadMember{member}, admin.PerformGetSquad(firstSquadId, nil, nil).Members)
+	assert.Empty(t, admin.PerformGetSquad(secondSquadId, nil, nil).Members)
+}
+
+func TestPOSTSquadMemberWillMoveMembersWhenAuthenticationIsDisabled(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	firstSquadId := tester.PerformPostSquad()
+	secondSquadId := tester.PerformPostSquad()
+	member := api.NewSquadMember("dale@example.com", api.Range{})
+	tester.PerformPostSquadMember(firstSquadId, member)
+
+	tester.PostSquadMember(secondSquadId, member).
+		CheckStatus(http.StatusAccepted)
+
+	assert.Empty(t, tester.PerformGetSquad(firstSquadId, nil, nil).Members)
+	assert.Equal(t, []api.SquadMember{member}, tester.PerformGetSquad(secondSquadId, nil, nil).Members)
+}
+
+func TestDELETESquadWillRemoveTheSquadAndItsMembers(t *testing.T) {
+	tester := testutil.New(t, mainHandler)
+	squadId := tester.PerformPostSquad()
+	member := api.NewSquadMember("dale@example.com", api.Range{})
+	tester.PerformPostSquadMember(squadId, member)
+
+	tester.DeleteSquad(squadId).
+		CheckStatus(http.StatusNoContent)
+
+	tester.GetSquad(squadId, nil, nil).
+		CheckStatus(http.StatusNotFound)
+	tester.PostSquadMember(squadId, member).
+		CheckStatus(http.StatusNotFound)
+	tester.DeleteSquad(squadId).
+		CheckStatus(http.StatusNotFound)
+}
+
+var tenancyConfig = service.Configuration{
+	DatabaseName: "SquadManagerTenancyTestDB",
+	Host:         "localhost",
+	DbTimeout:    time.Second,
+	Tenancy:      service.TenancyConfiguration{Mode: "path"},
+}
+
+func TestTenantsWillKeepTheirSquadsApart(t *testing.T) {
+	handler := service.MakeMainHandler(tenancyConfig)
+	defer handler.Close()
+	operator := testutil.New(t, handler)
+	operator.PerformPostTenant(api.Tenant{ID: "acme", Name: "Acme"})
+	operator.PerformPostTenant(api.Tenant{ID: "globex", Name: "Globex"})
+	defer operator.DeleteTenant("acme")
+	defer operator.DeleteTenant("globex")
+	acme := operator.ForTenant("acme")
+	globex := operator.ForTenant("globex")
+
+	squadId := acme.PerformPostSquad()
+
+	assert.Equal(t, []api.Squad{{ID: squadId, Members: []api.SquadMember{}}}, acme.PerformGetSquadList(nil, nil))
+	assert.Empty(t, globex.PerformGetSquadList(nil, nil))
+	globex.GetSquad(squadId, nil, nil).
+		CheckStatus(http.StatusNotFound)
+}
+
+func TestTenantsWillBeProvisionedAndRemoved(t *testing.T) {
+	handler := service.MakeMainHandler(tenancyConfig)
+	defer handler.Close()
+	operator := testutil.New(t, handler)
+
+	operator.ForTenant("initech").GetSquadList(nil, nil).
+		CheckStatus(http.StatusNotFound)
+	created := operator.PerformPostTenant(api.Tenant{ID: "initech", Name: "Initech"})
+	assert.Equal(t, "Initech", created.Name)
+	operator.PostTenant(api.Tenant{ID: "initech"}).
+		CheckStatus(http.StatusConflict)
+	operator.PostTenant(api.Tenant{ID: "Not Valid"}).
+		CheckStatus(http.StatusBadRequest)
+	operator.ForTenant("initech").PerformPostSquad()
+
+	operator.DeleteTenant("initech").
+		CheckStatus(http.StatusNoContent)
+	operator.ForTenant("initech").GetSquadList(nil, nil).
+		CheckStatus(http.StatusNotFound)
+	operator.DeleteTenant("initech").
+		CheckStatus(http.StatusNotFound)
+}
+
+func TestPUTSquadListWithOversizedBodyWillBeRejected(t *testing.T) {
+	limitedConfig := config
+	limitedConfig.Limits = service.LimitsConfiguration{MaxBodyBytes: 64}
+	handler := service.MakeMainHandler(limitedConfig)
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+	squads := []api.Squad{{ID: api.SquadId(bson.NewObjectId())}, {ID: api.SquadId(bson.NewObjectId())}}
+
+	tester.PutSquadList(squads).
+		CheckStatus(http.StatusRequestEntityTooLarge)
+}
+
+func squadMemberIndexKeys(t *testing.T, databaseName string) []string {
+	session, err := mgo.Dial("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	indexes, err := session.DB(databaseName).C("squadMember").Indexes()
+	if queryError, ok := err.(*mgo.QueryError); ok && queryError.Code == 26 {
+		// The collection has not been created yet.
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, index := range indexes {
+		keys = append(keys, strings.Join(index.Key, ","))
+	}
+	return keys
+}
+
+func dropDatabase(t *testing.T, databaseName string) {
+	session, err := mgo.Dial("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.DB(databaseName).DropDatabase(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectingWillCreateTheSquadMemberIndexes(t *testing.T) {
+	dropDatabase(t, "IndexBootstrapTest")
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "IndexBootstrapTest",
+		Host:         "localhost",
+		DbTimeout:    time.Second,
+	})
+	defer handler.Close()
+
+	tester := testutil.New(t, handler)
+	tester.PerformPostSquad()
+
+	wanted := []string{"_id", "email", "range.begin,range.end", "squadId,_id"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !assert.ObjectsAreEqual(wanted, sortedStrings(squadMemberIndexKeys(t, "IndexBootstrapTest"))) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, wanted, sortedStrings(squadMemberIndexKeys(t, "IndexBootstrapTest")))
+
+	tester.PerformPutSquadList([]api.Squad{})
+	assert.Equal(t, wanted, sortedStrings(squadMemberIndexKeys(t, "IndexBootstrapTest")),
+		"replacing the squads keeps the indexes")
+}
+
+func sortedStrings(values []string) []string {
+	sort.Strings(values)
+	return values
+}
+
+func TestConnectingWillLeaveIndexesAloneWhenSkipped(t *testing.T) {
+	dropDatabase(t, "IndexSkipTest")
+	handler := service.MakeMainHandler(service.Configuration{
+		DatabaseName: "IndexSkipTest",
+		Host:         "localhost",
+		DbTimeout:    time.Second,
+		SkipIndexes:  true,
+	})
+	defer handler.Close()
+	tester := testutil.New(t, handler)
+
+	squadId := tester.PerformPostSquad()
+	tester.PerformPostSquadMember(squadId, api.NewSquadMember("dale@fake.com", api.Range{
+		Begin: *api.Date(2017, 7, 30),
+		End:   *api.Date(2017, 8, 10),
+	}))
+
+	assert.Equal(t, []string{"_id"}, squadMemberIndexKeys(t, "IndexSkipTest"))
+}
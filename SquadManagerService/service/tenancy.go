@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+const tenantPathPrefix = "/tenants/"
+
+// TenancyConfiguration decides how each request names the tenant it belongs to. Without a mode the service holds a
+// single organisation in DatabaseName. With one, each tenant's squads live in a database of their own, and
+// DatabaseName only keeps the list of tenants.
+//
+// In "path" mode requests start with /tenants/{tenant}, in "subdomain" mode they are sent to {tenant}.{Domain}, and in
+// "claim" mode the tenant is read from the caller's token, so only tokens can reach a tenant's squads.
+type TenancyConfiguration struct {
+	Mode   string
+	Domain string
+}
+
+func (config TenancyConfiguration) enabled() bool {
+	return config.Mode != ""
+}
+
+func (config TenancyConfiguration) validate() error {
+	switch config.Mode {
+	case "", "path", "claim":
+		return nil
+	case "subdomain":
+		if config.Domain == "" {
+			return errors.New("tenancy.domain must be set for subdomain tenancy")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown tenancy mode %q", config.Mode)
+}
+
+var tenantIdPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+func validTenantId(tenant string) bool {
+	return tenantIdPattern.MatchString(tenant)
+}
+
+// tenantDatabase names the database holding a tenant's squads, keeping it next to the one listing the tenants.
+func tenantDatabase(databaseName string, tenant string) string {
+	if tenant == "" {
+		return databaseName
+	}
+	return databaseName + "_" + tenant
+}
+
+// UnknownTenantError reports a request that named no tenant, or one that has not been provisioned.
+type UnknownTenantError struct {
+	Tenant string
+}
+
+func (err *UnknownTenantError) Error() string {
+	if err.Tenant == "" {
+		return "a tenant must be named"
+	}
+	return fmt.Sprintf("unknown tenant %q", err.Tenant)
+}
+
+type tenantKey struct{}
+
+// TenantFrom returns the tenant the request belongs to, or "" when tenancy is disabled or none was named.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+func withTenant(request *http.Request, tenant string) *http.Request {
+	auditTenant(request, tenant)
+	return request.WithContext(context.WithValue(request.Context(), tenantKey{}, tenant))
+}
+
+// resolveTenant finds the tenant named by the path or host of each request, stripping the path prefix so that the
+// router sees the same paths whichever tenant is served. Tenants named in tokens are resolved by authorize instead.
+func (service *Context) resolveTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var tenant string
+		switch service.Tenancy.Mode {
+		case "path":
+			tenant, request = tenantFromPath(request)
+		case "subdomain":
+			tenant = tenantFromHost(request.Host, service.Tenancy.Domain)
+		}
+
+		if tenant != "" {
+			if !validTenantId(tenant) {
+				respond(writer, request, ResponseEntity{&UnknownTenantError{tenant}, http.StatusNotFound})
+				return
+			}
+			request = withTenant(request, tenant)
+		}
+		next(writer, request)
+	}
+}
+
+func tenantFromPath(request *http.Request) (string, *http.Request) {
+	if !strings.HasPrefix(request.URL.Path, tenantPathPrefix) {
+		return "", request
+	}
+
+	rest := request.URL.Path[len(tenantPathPrefix):]
+	tenant, path := rest, "/"
+	if slash := strings.Index(rest, "/"); slash >= 0 {
+		tenant, path = rest[:slash], rest[slash:]
+	}
+
+	rewritten := *request
+	target := *request.URL
+	target.Path = path
+	target.RawPath = ""
+	rewritten.URL = &target
+	return tenant, &rewritten
+}
+
+func tenantFromHost(host string, domain string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(domain)
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	return strings.TrimSuffix(host, suffix)
+}
+
+// inTenant tells whether the caller may act within the tenant of the request. Callers belong to the tenant their key
+// was issued in or that their token names; admins belonging to no tenant operate the whole deployment.
+func (service *Context) inTenant(identity *Identity, request *http.Request) bool {
+	if !service.Tenancy.enabled() {
+		return true
+	}
+	if identity.Tenant == "" && identity.hasRole(api.RoleAdmin) {
+		return true
+	}
+	return identity.Tenant == TenantFrom(request.Context())
+}
+
+// repository opens the storage of the request's tenant, once it is known to have been provisioned.
+func (service *Context) repository(ctx context.Context) (*SquadRepository, error) {
+	if !service.Tenancy.enabled() {
+		return service.RepositoryFactory.Repository()
+	}
+
+	tenant := TenantFrom(ctx)
+	if tenant == "" {
+		return nil, &UnknownTenantError{}
+	}
+
+	control, err := service.RepositoryFactory.Repository()
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+
+	found, err := control.findTenant(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &UnknownTenantError{tenant}
+	}
+	return service.RepositoryFactory.TenantRepository(tenant)
+}
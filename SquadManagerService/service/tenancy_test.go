@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func tenantRecorder(tenant *string, path *string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		*tenant = TenantFrom(request.Context())
+		*path = request.URL.Path
+	}
+}
+
+func TestResolveTenantWillStripThePathPrefix(t *testing.T) {
+	context := &Context{Tenancy: TenancyConfiguration{Mode: "path"}}
+	cases := map[string][2]string{
+		"/tenants/acme/squad/5a0e6b1bd2b0f6f2a5c3a7e2": {"acme", "/squad/5a0e6b1bd2b0f6f2a5c3a7e2"},
+		"/tenants/acme":     {"acme", "/"},
+		"/squad":            {"", "/squad"},
+		"/tenantsquad/acme": {"", "/tenantsquad/acme"},
+	}
+
+	for path, expected := range cases {
+		var tenant, routed string
+		context.resolveTenant(tenantRecorder(&tenant, &routed))(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+
+		assert.Equal(t, expected[0], tenant, path)
+		assert.Equal(t, expected[1], routed, path)
+	}
+}
+
+func TestResolveTenantWillReadTheSubdomain(t *testing.T) {
+	context := &Context{Tenancy: TenancyConfiguration{Mode: "subdomain", Domain: "squads.example.com"}}
+	cases := map[string]string{
+		"acme.squads.example.com":      "acme",
+		"ACME.Squads.Example.com:8080": "acme",
+		"squads.example.com":           "",
+		"acme.example.com":             "",
+	}
+
+	for host, expected := range cases {
+		var tenant, routed string
+		request := httptest.NewRequest("GET", "/squad", nil)
+		request.Host = host
+		context.resolveTenant(tenantRecorder(&tenant, &routed))(httptest.NewRecorder(), request)
+
+		assert.Equal(t, expected, tenant, host)
+		assert.Equal(t, "/squad", routed, host)
+	}
+}
+
+func TestResolveTenantWillRejectMalformedTenants(t *testing.T) {
+	context := &Context{Tenancy: TenancyConfiguration{Mode: "path"}}
+	called := false
+	recorder := httptest.NewRecorder()
+
+	context.resolveTenant(func(http.ResponseWriter, *http.Request) { called = true })(
+		recorder, httptest.NewRequest("GET", "/tenants/ACME_Corp/squad", nil))
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestAuthorizeWillKeepCallersWithinTheirTenant(t *testing.T) {
+	signer := newEcdsaSigner(t, "ec-1")
+	keys := newJwksServer(signer)
+	defer keys.Close()
+	context := newAuthTestContext(AuthConfiguration{Enabled: true})
+	context.Tokens = newTestVerifier(keys.URL)
+	context.Tenancy = TenancyConfiguration{Mode: "path"}
+	var seen *Identity
+	handle := context.authorize(canView, identityRecorder(&seen))
+
+	serve := func(path string, claims map[string]interface{}) int {
+		var status int
+		context.resolveTenant(func(writer http.ResponseWriter, request *http.Request) {
+			recorder := httptest.NewRecorder()
+			handle(recorder, request, httprouter.Params{})
+			status = recorder.Code
+		})(httptest.NewRecorder(), withBearer(httptest.NewRequest("GET", path, nil), signer.sign(t, claims)))
+		return status
+	}
+	member := validClaims()
+	member["tenant"] = "acme"
+	operator := validClaims()
+	operator["roles"] = "admin"
+
+	assert.Equal(t, http.StatusOK, serve("/tenants/acme/squad", member))
+	assert.Equal(t, "acme", seen.Tenant)
+	assert.Equal(t, http.StatusForbidden, serve("/tenants/globex/squad", member))
+	assert.Equal(t, http.StatusForbidden, serve("/tenants/acme/squad", validClaims()))
+	assert.Equal(t, http.StatusOK, serve("/tenants/globex/squad", operator))
+}
+
+func TestAuthorizeWillTakeTheTenantFromTheTokenInClaimMode(t *testing.T) {
+	signer := newEcdsaSigner(t, "ec-1")
+	keys := newJwksServer(signer)
+	defer keys.Close()
+	context := newAuthTestContext(AuthConfiguration{Enabled: true})
+	context.Tokens = newTestVerifier(keys.URL)
+	context.Tenancy = TenancyConfiguration{Mode: "claim"}
+	var tenant string
+	handle := context.authorize(canView, func(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		tenant = TenantFrom(request.Context())
+	})
+	claims := validClaims()
+	claims["tenant"] = "acme"
+
+	handle(httptest.NewRecorder(), withBearer(httptest.NewRequest("GET", "/squad", nil), signer.sign(t, claims)), nil)
+
+	assert.Equal(t, "acme", tenant)
+}
+
+func TestTenantRoutesWillRequireATenant(t *testing.T) {
+	handler := MakeMainHandler(Configuration{
+		Host:         "missing",
+		DatabaseName: "TenancyTest",
+		DbTimeout:    time.Millisecond / 100,
+		Tenancy:      TenancyConfiguration{Mode: "path"},
+	})
+	defer handler.Close()
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/squad", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	var body api.Error
+	assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&body))
+	assert.Equal(t, "a tenant must be named", body.Message)
+}
+
+func TestValidateWillCheckTenancySettings(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Tenancy = TenancyConfiguration{Mode: "subdomain"}
+	assert.EqualError(t, config.Validate(), "invalid configuration: tenancy.domain must be set for subdomain tenancy")
+
+	config.Tenancy = TenancyConfiguration{Mode: "claim"}
+	assert.EqualError(t, config.Validate(), "invalid configuration: claim tenancy needs auth.enabled and auth.jwt.jwks")
+
+	config.Tenancy = TenancyConfiguration{Mode: "header"}
+	assert.EqualError(t, config.Validate(), `invalid configuration: unknown tenancy mode "header"`)
+}
+
+func withBearer(request *http.Request, token string) *http.Request {
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (repository SquadRepository) TenantCollection() *mgo.Collection {
+	return repository.Database().C("tenant")
+}
+
+// addTenant registers a tenant, reporting false when one with the same id already exists.
+func (repository SquadRepository) addTenant(ctx context.Context, tenant api.Tenant) (api.Tenant, bool, error) {
+	tenant.Created = time.Now().UTC()
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		if err := repository.TenantCollection().Insert(toTenantDocument(tenant)); err != nil {
+			return err
+		}
+		tenantRepository := repository
+		tenantRepository.tenant = tenant.ID
+		tenantRepository.bootstrapIndexes()
+		return nil
+	})
+	if mgo.IsDup(err) {
+		return tenant, false, nil
+	}
+	return tenant, err == nil, err
+}
+
+func (repository SquadRepository) listTenants(ctx context.Context) ([]api.Tenant, error) {
+	var documents []TenantDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.TenantCollection().Find(bson.M{}).Sort("_id").All(&documents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]api.Tenant, len(documents))
+	for index, document := range documents {
+		tenants[index] = toApiTenant(document)
+	}
+	return tenants, nil
+}
+
+func (repository SquadRepository) findTenant(ctx context.Context, id string) (*api.Tenant, error) {
+	var document TenantDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.TenantCollection().FindId(id).One(&document)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tenant := toApiTenant(document)
+	return &tenant, nil
+}
+
+// deleteTenant forgets a tenant and drops the database holding its squads, members, webhooks and keys.
+func (repository SquadRepository) deleteTenant(ctx context.Context, id string) (bool, error) {
+	deleted := false
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		if err := repository.TenantCollection().RemoveId(id); err == mgo.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		deleted = true
+		return repository.session.DB(tenantDatabase(repository.Config.DatabaseName, id)).DropDatabase()
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+func toTenantDocument(tenant api.Tenant) TenantDocument {
+	return TenantDocument{
+		ID:      tenant.ID,
+		Name:    tenant.Name,
+		Created: tenant.Created,
+	}
+}
+
+func toApiTenant(document TenantDocument) api.Tenant {
+	return api.Tenant{
+		ID:      document.ID,
+		Name:    document.Name,
+		Created: document.Created.UTC(),
+	}
+}
+
+type TenantDocument struct {
+	ID      string    `bson:"_id"`
+	Name    string    `bson:"name"`
+	Created time.Time `bson:"created"`
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+func listTenants(ctx context.Context, _ *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	tenants, err := repository.listTenants(ctx)
+	return ResponseEntity{tenants, http.StatusOK}, err
+}
+
+func createTenant(ctx context.Context, request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	var tenant api.Tenant
+	if rejected := decodeBody(request, &tenant); rejected != nil {
+		return *rejected, nil
+	}
+	tenant.Name = strings.TrimSpace(tenant.Name)
+	if !validTenantId(tenant.ID) {
+		err := fmt.Errorf("tenant id %q must be 1 to 40 lowercase letters, digits or dashes", tenant.ID)
+		return ResponseEntity{err, http.StatusBadRequest}, nil
+	}
+
+	created, added, err := repository.addTenant(ctx, tenant)
+	if err != nil {
+		return ResponseEntity{}, err
+	}
+	if !added {
+		return ResponseEntity{fmt.Errorf("tenant %q already exists", tenant.ID), http.StatusConflict}, nil
+	}
+	return ResponseEntity{created, http.StatusAccepted}, nil
+}
+
+func deleteTenant(ctx context.Context, _ *http.Request, params httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	deleted, err := repository.deleteTenant(ctx, params.ByName("tenant"))
+	if err != nil || !deleted {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+	return ResponseEntity{code: http.StatusNoContent}, nil
+}
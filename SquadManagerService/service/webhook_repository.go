@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (repository SquadRepository) WebhookCollection() *mgo.Collection {
+	return repository.Database().C("webhook")
+}
+
+func (repository SquadRepository) WebhookDeliveryCollection() *mgo.Collection {
+	return repository.Database().C("webhookDelivery")
+}
+
+func (repository SquadRepository) addWebhook(ctx context.Context, webhook api.Webhook) (api.Webhook, error) {
+	webhook.ID = api.WebhookId(bson.NewObjectId())
+	return webhook, repository.write(ctx, func(repository SquadRepository) error {
+		return repository.WebhookCollection().Insert(toWebhookDocument(webhook))
+	})
+}
+
+func (repository SquadRepository) listWebhooks(ctx context.Context) ([]api.Webhook, error) {
+	var documents []WebhookDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.WebhookCollection().Find(bson.M{}).All(&documents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]api.Webhook, len(documents))
+	for index, document := range documents {
+		webhooks[index] = toApiWebhook(document)
+	}
+	return webhooks, nil
+}
+
+func (repository SquadRepository) getWebhook(ctx context.Context, idString string) (*api.Webhook, error) {
+	if !bson.IsObjectIdHex(idString) {
+		return nil, nil
+	}
+
+	var document WebhookDocument
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.WebhookCollection().FindId(bson.ObjectIdHex(idString)).One(&document)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	webhook := toApiWebhook(document)
+	return &webhook, nil
+}
+
+func (repository SquadRepository) updateWebhook(ctx context.Context, webhook api.Webhook) error {
+	return repository.write(ctx, func(repository SquadRepository) error {
+		return repository.WebhookCollection().UpdateId(bson.ObjectId(webhook.ID), toWebhookDocument(webhook))
+	})
+}
+
+func (repository SquadRepository) deleteWebhook(ctx context.Context, idString string) (bool, error) {
+	if !bson.IsObjectIdHex(idString) {
+		return false, nil
+	}
+
+	err := repository.write(ctx, func(repository SquadRepository) error {
+		return repository.WebhookCollection().RemoveId(bson.ObjectIdHex(idString))
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (repository SquadRepository) recordWebhookDelivery(ctx context.Context, delivery api.WebhookDelivery) error {
+	return repository.write(ctx, func(repository SquadRepository) error {
+		return repository.WebhookDeliveryCollection().Insert(toWebhookDeliveryDocument(delivery))
+	})
+}
+
+func (repository SquadRepository) listWebhookDeliveries(ctx context.Context, webhookId api.WebhookId) ([]api.WebhookDelivery, error) {
+	var documents []WebhookDeliveryDocument
+	query := bson.M{"webhookId": bson.ObjectId(webhookId)}
+	err := repository.run(ctx, func(repository SquadRepository) error {
+		return repository.WebhookDeliveryCollection().Find(query).Sort("time").All(&documents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]api.WebhookDelivery, len(documents))
+	for index, document := range documents {
+		deliveries[index] = toApiWebhookDelivery(document)
+	}
+	return deliveries, nil
+}
+
+func toWebhookDocument(webhook api.Webhook) WebhookDocument {
+	events := make([]string, len(webhook.Events))
+	for index, eventType := range webhook.Events {
+		events[index] = string(eventType)
+	}
+	return WebhookDocument{
+		ID:     bson.ObjectId(webhook.ID),
+		URL:    webhook.URL,
+		Events: events,
+		Secret: webhook.Secret,
+	}
+}
+
+func toApiWebhook(document WebhookDocument) api.Webhook {
+	events := make([]api.EventType, len(document.Events))
+	for index, eventType := range document.Events {
+		events[index] = api.EventType(eventType)
+	}
+	return api.Webhook{
+		ID:     api.WebhookId(document.ID),
+		URL:    document.URL,
+		Events: events,
+		Secret: document.Secret,
+	}
+}
+
+func toWebhookDeliveryDocument(delivery api.WebhookDelivery) WebhookDeliveryDocument {
+	return WebhookDeliveryDocument{
+		ID:         bson.ObjectIdHex(delivery.ID),
+		WebhookID:  bson.ObjectId(delivery.WebhookID),
+		EventID:    delivery.EventID,
+		EventType:  string(delivery.EventType),
+		Attempt:    delivery.Attempt,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Success:    delivery.Success,
+		Time:       delivery.Time,
+	}
+}
+
+func toApiWebhookDelivery(document WebhookDeliveryDocument) api.WebhookDelivery {
+	return api.WebhookDelivery{
+		ID:         document.ID.Hex(),
+		WebhookID:  api.WebhookId(document.WebhookID),
+		EventID:    document.EventID,
+		EventType:  api.EventType(document.EventType),
+		Attempt:    document.Attempt,
+		StatusCode: document.StatusCode,
+		Error:      document.Error,
+		Success:    document.Success,
+		Time:       document.Time.UTC(),
+	}
+}
+
+type WebhookDocument struct {
+	ID     bson.ObjectId `bson:"_id,omitempty"`
+	URL    string        `bson:"url"`
+	Events []string      `bson:"events"`
+	Secret string        `bson:"secret"`
+}
+
+type WebhookDeliveryDocument struct {
+	ID         bson.ObjectId `bson:"_id,omitempty"`
+	WebhookID  bson.ObjectId `bson:"webhookId"`
+	EventID    string        `bson:"eventId"`
+	EventType  string        `bson:"eventType"`
+	Attempt    int           `bson:"attempt"`
+	StatusCode int           `bson:"statusCode"`
+	Error      string        `bson:"error"`
+	Success    bool          `bson:"success"`
+	Time       time.Time     `bson:"time"`
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+)
+
+func listWebhooks(ctx context.Context, _ *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	webhooks, err := repository.listWebhooks(ctx)
+	for index := range webhooks {
+		webhooks[index].Secret = ""
+	}
+	return ResponseEntity{webhooks, http.StatusOK}, err
+}
+
+func createWebhook(ctx context.Context, request *http.Request, _ httprouter.Params, repository *SquadRepository) (ResponseEntity, error) {
+	var webhook api.Webhook
+	if rejected := decodeBody(request, &webhook); rejected != nil {
+		return *rejected, nil
+	}
+	if err := validateWebhook(webhook); err != nil {
+		return ResponseEntity{err, http.StatusBadRequest}, nil
+	}
+
+	if webhook.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return ResponseEntity{}, err
+		}
+		webhook.Secret = secret
+	}
+
+	webhook, err := repository.addWebhook(ctx, webhook)
+	return ResponseEntity{webhook, http.StatusAccepted}, err
+}
+
+func getWebhook(ctx context.Context, _ *http.Request, repository *SquadRepository, webhookId string) (ResponseEntity, error) {
+	webhook, err := repository.getWebhook(ctx, webhookId)
+	if err != nil {
+		return ResponseEntity{}, err
+	}
+	if webhook == nil {
+		return ResponseEntity{code: http.StatusNotFound}, nil
+	}
+
+	webhook.Secret = ""
+	return ResponseEntity{webhook, http.StatusOK}, nil
+}
+
+func updateWebhook(ctx context.Context, request *http.Request, repository *SquadRepository, webhookId string) (ResponseEntity, error) {
+	var webhook api.Webhook
+	if rejected := decodeBody(request, &webhook); rejected != nil {
+		return *rejected, nil
+	}
+	if err := validateWebhook(webhook); err != nil {
+		return ResponseEntity{err, http.StatusBadRequest}, nil
+	}
+
+	existing, err := repository.getWebhook(ctx, webhookId)
+	if err != nil || existing == nil {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+
+	webhook.ID = existing.ID
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	if err := repository.updateWebhook(ctx, webhook); err != nil {
+		return ResponseEntity{}, err
+	}
+
+	webhook.Secret = ""
+	return ResponseEntity{webhook, http.StatusOK}, nil
+}
+
+func deleteWebhook(ctx context.Context, _ *http.Request, repository *SquadRepository, webhookId string) (ResponseEntity, error) {
+	deleted, err := repository.deleteWebhook(ctx, webhookId)
+	if err != nil || !deleted {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+	return ResponseEntity{code: http.StatusNoContent}, nil
+}
+
+func listWebhookDeliveries(ctx context.Context, _ *http.Request, repository *SquadRepository, webhookId string) (ResponseEntity, error) {
+	webhook, err := repository.getWebhook(ctx, webhookId)
+	if err != nil || webhook == nil {
+		return ResponseEntity{code: http.StatusNotFound}, err
+	}
+
+	deliveries, err := repository.listWebhookDeliveries(ctx, webhook.ID)
+	return ResponseEntity{deliveries, http.StatusOK}, err
+}
+
+func validateWebhook(webhook api.Webhook) error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil {
+		return err
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("webhook URL must be an absolute http or https URL")
+	}
+
+	for _, eventType := range webhook.Events {
+		if !eventType.IsValid() {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type WebhookConfiguration struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+func (config WebhookConfiguration) withDefaults() WebhookConfiguration {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return config
+}
+
+type EventPublisher interface {
+	Publish(event api.Event)
+}
+
+type webhookStore interface {
+	listWebhooks(ctx context.Context) ([]api.Webhook, error)
+	recordWebhookDelivery(ctx context.Context, delivery api.WebhookDelivery) error
+	Close()
+}
+
+type WebhookDispatcher struct {
+	config    WebhookConfiguration
+	logger    *Logger
+	openStore func(tenant string) (webhookStore, error)
+	client    *http.Client
+	pending   sync.WaitGroup
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebhookDispatcher(config WebhookConfiguration, logger *Logger, openStore func(tenant string) (webhookStore, error)) *WebhookDispatcher {
+	config = config.withDefaults()
+	return &WebhookDispatcher{
+		config:    config,
+		logger:    logger,
+		openStore: openStore,
+		client:    &http.Client{Timeout: config.Timeout},
+		closing:   make(chan struct{}),
+	}
+}
+
+func (dispatcher *WebhookDispatcher) Publish(event api.Event) {
+	dispatcher.pending.Add(1)
+	go func() {
+		defer dispatcher.pending.Done()
+		dispatcher.dispatch(event)
+	}()
+}
+
+// Close stops any pending retries and waits for deliveries already in flight to finish.
+func (dispatcher *WebhookDispatcher) Close() {
+	dispatcher.closeOnce.Do(func() { close(dispatcher.closing) })
+	dispatcher.pending.Wait()
+}
+
+func (dispatcher *WebhookDispatcher) dispatch(event api.Event) {
+	logger := dispatcher.logger.With("eventType", event.Type, "eventId", event.ID)
+	store, err := dispatcher.openStore(event.Tenant)
+	if err != nil {
+		logger.Error("webhook dispatch failed", "error", err)
+		return
+	}
+	defer store.Close()
+
+	webhooks, err := store.listWebhooks(context.Background())
+	if err != nil {
+		logger.Error("webhook dispatch failed", "error", err)
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("webhook dispatch failed", "error", err)
+		return
+	}
+
+	var deliveries sync.WaitGroup
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event.Type) {
+			continue
+		}
+		deliveries.Add(1)
+		go func(webhook api.Webhook) {
+			defer deliveries.Done()
+			dispatcher.deliver(store, webhook, event, payload)
+		}(webhook)
+	}
+	deliveries.Wait()
+}
+
+func (dispatcher *WebhookDispatcher) deliver(store webhookStore, webhook api.Webhook, event api.Event, payload []byte) {
+	backoff := dispatcher.config.InitialBackoff
+	for attempt := 1; attempt <= dispatcher.config.MaxAttempts; attempt++ {
+		delivery := dispatcher.attempt(webhook, event, payload, attempt)
+		if err := store.recordWebhookDelivery(context.Background(), delivery); err != nil {
+			dispatcher.logger.Error("webhook delivery could not be recorded",
+				"webhookId", webhook.ID, "eventId", event.ID, "deliveryId", delivery.ID, "error", err)
+		}
+		if !delivery.Success {
+			dispatcher.logger.Warn("webhook delivery failed",
+				"webhookId", webhook.ID, "eventId", event.ID, "attempt", attempt, "error", delivery.Error)
+		}
+		if delivery.Success || attempt == dispatcher.config.MaxAttempts {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-dispatcher.closing:
+			return
+		}
+
+		backoff *= 2
+		if backoff > dispatcher.config.MaxBackoff {
+			backoff = dispatcher.config.MaxBackoff
+		}
+	}
+}
+
+func (dispatcher *WebhookDispatcher) attempt(webhook api.Webhook, event api.Event, payload []byte, attempt int) api.WebhookDelivery {
+	delivery := api.WebhookDelivery{
+		ID:        bson.NewObjectId().Hex(),
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Attempt:   attempt,
+		Time:      time.Now().UTC(),
+	}
+
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(api.WebhookEventHeader, string(event.Type))
+	request.Header.Set(api.WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(api.WebhookSignatureHeader, api.SignWebhookPayload(webhook.Secret, payload))
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+	delivery.Success = response.StatusCode >= 200 && response.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("receiver responded with %d", response.StatusCode)
+	}
+	return delivery
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeWebhookStore struct {
+	webhooks   []api.Webhook
+	mutex      sync.Mutex
+	deliveries []api.WebhookDelivery
+}
+
+func (store *fakeWebhookStore) listWebhooks(_ context.Context) ([]api.Webhook, error) {
+	return store.webhooks, nil
+}
+
+func (store *fakeWebhookStore) recordWebhookDelivery(_ context.Context, delivery api.WebhookDelivery) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.deliveries = append(store.deliveries, delivery)
+	return nil
+}
+
+func (store *fakeWebhookStore) Close() {
+}
+
+func newTestDispatcher(store *fakeWebhookStore) *WebhookDispatcher {
+	return newWebhookDispatcher(
+		WebhookConfiguration{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		newDefaultLogger(LoggingConfiguration{Level: "error"}),
+		func(string) (webhookStore, error) { return store, nil },
+	)
+}
+
+func TestWebhookDispatcherWillDeliverSignedPayload(t *testing.T) {
+	var received []byte
+	var signature string
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received, _ = ioutil.ReadAll(request.Body)
+		signature = request.Header.Get(api.WebhookSignatureHeader)
+		assert.Equal(t, string(api.SquadCreated), request.Header.Get(api.WebhookEventHeader))
+	}))
+	defer receiver.Close()
+
+	store := &fakeWebhookStore{webhooks: []api.Webhook{
+		{ID: api.WebhookId(bson.NewObjectId()), URL: receiver.URL, Secret: "shhh"},
+	}}
+	dispatcher := newTestDispatcher(store)
+
+	event := api.NewEvent(api.SquadCreated)
+	dispatcher.Publish(event)
+	dispatcher.pending.Wait()
+
+	assert.True(t, api.VerifyWebhookSignature("shhh", received, signature))
+	assert.Contains(t, string(received), event.ID)
+	assert.Equal(t, 1, len(store.deliveries))
+	assert.True(t, store.deliveries[0].Success)
+	assert.Equal(t, http.StatusOK, store.deliveries[0].StatusCode)
+}
+
+func TestWebhookDispatcherWillRetryFailedDeliveries(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		attempts++
+		if attempts < 2 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	store := &fakeWebhookStore{webhooks: []api.Webhook{
+		{ID: api.WebhookId(bson.NewObjectId()), URL: receiver.URL},
+	}}
+	dispatcher := newTestDispatcher(store)
+
+	dispatcher.Publish(api.NewEvent(api.MemberUpserted))
+	dispatcher.pending.Wait()
+
+	assert.Equal(t, 2, len(store.deliveries))
+	assert.False(t, store.deliveries[0].Success)
+	assert.Equal(t, http.StatusServiceUnavailable, store.deliveries[0].StatusCode)
+	assert.True(t, store.deliveries[1].Success)
+	assert.Equal(t, 2, store.deliveries[1].Attempt)
+}
+
+func TestWebhookDispatcherWillGiveUpAfterMaxAttempts(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	store := &fakeWebhookStore{webhooks: []api.Webhook{
+		{ID: api.WebhookId(bson.NewObjectId()), URL: receiver.URL},
+	}}
+	dispatcher := newTestDispatcher(store)
+
+	dispatcher.Publish(api.NewEvent(api.MemberUpserted))
+	dispatcher.pending.Wait()
+
+	assert.Equal(t, 3, len(store.deliveries))
+	for _, delivery := range store.deliveries {
+		assert.False(t, delivery.Success)
+	}
+}
+
+func TestWebhookDispatcherWillSkipUnsubscribedEvents(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		t.Error("Receiver should not have been called.")
+	}))
+	defer receiver.Close()
+
+	store := &fakeWebhookStore{webhooks: []api.Webhook{
+		{ID: api.WebhookId(bson.NewObjectId()), URL: receiver.URL, Events: []api.EventType{api.SquadCreated}},
+	}}
+	dispatcher := newTestDispatcher(store)
+
+	dispatcher.Publish(api.NewEvent(api.SquadListOverwritten))
+	dispatcher.pending.Wait()
+
+	assert.Equal(t, 0, len(store.deliveries))
+}
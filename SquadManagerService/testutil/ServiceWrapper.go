@@ -2,41 +2,114 @@ package testutil
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"net/url"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/robertfmurdock/SquadManager/SquadManagerService/api"
 	"github.com/stretchr/testify/assert"
 )
 
+// Tester makes requests of a handler on behalf of a test. When the handler serves an OpenAPI document, every request
+// and response is checked against it, failing the test on anything the document does not allow.
 type Tester struct {
-	t       *testing.T
-	Handler http.Handler
+	t        *testing.T
+	Handler  http.Handler
+	apiKey   string
+	prefix   string
+	header   http.Header
+	contract *contractSource
 }
 
 func New(t *testing.T, handler http.Handler) *Tester {
-	return &Tester{t, handler}
+	return &Tester{t: t, Handler: handler, contract: &contractSource{}}
+}
+
+// WithApiKey returns a tester that authenticates its requests with the given key.
+func (tester *Tester) WithApiKey(apiKey string) *Tester {
+	derived := *tester
+	derived.apiKey = apiKey
+	return &derived
+}
+
+// ForTenant returns a tester that sends its requests to the given tenant, named by path.
+func (tester *Tester) ForTenant(tenant string) *Tester {
+	derived := *tester
+	derived.prefix = "/tenants/" + tenant
+	return &derived
+}
+
+// WithHeader returns a tester that sends the given header along with each of its requests.
+func (tester *Tester) WithHeader(name string, value string) *Tester {
+	derived := *tester
+	derived.header = http.Header{}
+	for key, values := range tester.header {
+		derived.header[key] = values
+	}
+	derived.header.Set(name, value)
+	return &derived
 }
 
 func (tester *Tester) PerformRequest(request *http.Request) *httptest.ResponseRecorder {
+	var requestBody []byte
+	if request.Body != nil {
+		var err error
+		if requestBody, err = ioutil.ReadAll(request.Body); err != nil {
+			tester.t.Fatal(err)
+		}
+		request.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
 	recorder := httptest.NewRecorder()
 	tester.Handler.ServeHTTP(recorder, request)
+	tester.checkContract(request, requestBody, recorder)
 	return recorder
 }
 
+// checkContract holds the exchange to the handler's OpenAPI document, with the path as the document names it.
+func (tester *Tester) checkContract(request *http.Request, requestBody []byte, recorder *httptest.ResponseRecorder) {
+	contract := tester.contract.load(tester.Handler)
+	if contract == nil {
+		return
+	}
+	responseBody, err := decodeBody(recorder)
+	if err != nil {
+		tester.t.Error(err)
+		return
+	}
+
+	documented := *request
+	documentedURL := *request.URL
+	documentedURL.Path = strings.TrimPrefix(documentedURL.Path, tester.prefix)
+	documented.URL = &documentedURL
+	for _, problem := range contract.check(&documented, requestBody, recorder, responseBody) {
+		tester.t.Error("breaks the API contract: " + problem)
+	}
+}
+
 func (tester *Tester) DoRequest(method, urlStr string, body interface{}) Response {
 	value, err := getPostBody(body)
 	if err != nil {
 		tester.t.Fatal(err)
 	}
 	bodyReader := bytes.NewReader(value)
-	request := newRequest(tester.t, method, urlStr, bodyReader)
+	request := newRequest(tester.t, method, tester.prefix+urlStr, bodyReader)
+	for key, values := range tester.header {
+		request.Header[key] = values
+	}
+	if tester.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+tester.apiKey)
+	}
 	return Response{tester, tester.PerformRequest(request)}
 }
 
@@ -46,6 +119,28 @@ func (tester *Tester) GetSquadList(begin *time.Time, end *time.Time) Response {
 	return tester.DoRequest("GET", squadUrl.String(), nil)
 }
 
+// PerformGetSquadPages lists squads with the given parameters, following the next links until the last page.
+func (tester *Tester) PerformGetSquadPages(values url.Values) [][]api.Squad {
+	var pages [][]api.Squad
+	target := tester.urlWithValues("/squad", &values).String()
+	for target != "" {
+		var page []api.Squad
+		response := tester.DoRequest("GET", target, nil).
+			CheckStatus(http.StatusOK).
+			LoadJson(&page)
+		pages = append(pages, page)
+		target = strings.TrimPrefix(nextLink(response.Recorder.Header().Get("Link")), tester.prefix)
+	}
+	return pages
+}
+
+func nextLink(header string) string {
+	if !strings.HasPrefix(header, "<") || !strings.HasSuffix(header, `>; rel="next"`) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(header, "<"), `>; rel="next"`)
+}
+
 func (tester *Tester) PutSquadList(squadList []api.Squad) Response {
 	return tester.DoRequest("PUT", "/squad", squadList)
 }
@@ -89,6 +184,14 @@ func (tester *Tester) PostSquadMember(squadId api.SquadId, member api.SquadMembe
 	return tester.DoRequest("POST", "/squad/"+squadId.String(), member)
 }
 
+func (tester *Tester) DeleteSquad(squadId api.SquadId) Response {
+	return tester.DoRequest("DELETE", "/squad/"+squadId.String(), nil)
+}
+
+func (tester *Tester) DeleteSquadMember(squadId api.SquadId, memberId api.SquadMemberId) Response {
+	return tester.DoRequest("DELETE", "/squad/"+squadId.String()+"/member/"+memberId.String(), nil)
+}
+
 func (tester *Tester) PerformPostSquad() api.SquadId {
 	var newSquadId api.SquadId
 	tester.PostSquad().
@@ -130,6 +233,82 @@ func (tester *Tester) PerformPostSquadMember(squadId api.SquadId, squadMember ap
 	assert.Equal(tester.t, squadMember.ID, newSquadMemberId)
 }
 
+func (tester *Tester) PostWebhook(webhook api.Webhook) Response {
+	return tester.DoRequest("POST", "/webhook", webhook)
+}
+
+func (tester *Tester) GetWebhook(webhookId api.WebhookId) Response {
+	return tester.DoRequest("GET", "/webhook/"+webhookId.String(), nil)
+}
+
+func (tester *Tester) DeleteWebhook(webhookId api.WebhookId) Response {
+	return tester.DoRequest("DELETE", "/webhook/"+webhookId.String(), nil)
+}
+
+func (tester *Tester) PerformPostWebhook(webhook api.Webhook) api.Webhook {
+	var created api.Webhook
+	tester.PostWebhook(webhook).
+		CheckStatus(http.StatusAccepted).
+		LoadJson(&created)
+	return created
+}
+
+func (tester *Tester) PerformGetWebhookList() []api.Webhook {
+	var webhooks []api.Webhook
+	tester.DoRequest("GET", "/webhook", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&webhooks)
+	return webhooks
+}
+
+func (tester *Tester) PerformGetWebhookDeliveries(webhookId api.WebhookId) []api.WebhookDelivery {
+	var deliveries []api.WebhookDelivery
+	tester.DoRequest("GET", "/webhook/"+webhookId.String()+"/delivery", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&deliveries)
+	return deliveries
+}
+
+func (tester *Tester) PostApiKey(apiKey api.ApiKey) Response {
+	return tester.DoRequest("POST", "/apikey", apiKey)
+}
+
+func (tester *Tester) RevokeApiKey(apiKeyId api.ApiKeyId) Response {
+	return tester.DoRequest("DELETE", "/apikey/"+apiKeyId.String(), nil)
+}
+
+func (tester *Tester) PerformPostApiKey(apiKey api.ApiKey) api.ApiKey {
+	var created api.ApiKey
+	tester.PostApiKey(apiKey).
+		CheckStatus(http.StatusAccepted).
+		LoadJson(&created)
+	return created
+}
+
+func (tester *Tester) PerformGetApiKeyList() []api.ApiKey {
+	var apiKeys []api.ApiKey
+	tester.DoRequest("GET", "/apikey", nil).
+		CheckStatus(http.StatusOK).
+		LoadJson(&apiKeys)
+	return apiKeys
+}
+
+func (tester *Tester) PostTenant(tenant api.Tenant) Response {
+	return tester.DoRequest("POST", "/tenant", tenant)
+}
+
+func (tester *Tester) DeleteTenant(tenantId string) Response {
+	return tester.DoRequest("DELETE", "/tenant/"+tenantId, nil)
+}
+
+func (tester *Tester) PerformPostTenant(tenant api.Tenant) api.Tenant {
+	var created api.Tenant
+	tester.PostTenant(tenant).
+		CheckStatus(http.StatusAccepted).
+		LoadJson(&created)
+	return created
+}
+
 type Response struct {
 	Tester   *Tester
 	Recorder *httptest.ResponseRecorder
@@ -144,18 +323,48 @@ func (response Response) CheckStatus(status int) Response {
 }
 
 func (response Response) LoadJson(loadLocation interface{}) Response {
-	err := json.Unmarshal(response.Recorder.Body.Bytes(), loadLocation)
+	body := response.Body()
+	err := json.Unmarshal(body, loadLocation)
 	if err != nil {
-		response.Tester.t.Fatal(err, response.Recorder.Body.String())
+		response.Tester.t.Fatal(err, string(body))
 	}
 	return response
 }
 
+// Body is the body of the response, decompressed according to its Content-Encoding as an HTTP client would.
+func (response Response) Body() []byte {
+	body, err := decodeBody(response.Recorder)
+	if err != nil {
+		response.Tester.t.Fatal(err)
+	}
+	return body
+}
+
+func decodeBody(recorder *httptest.ResponseRecorder) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(recorder.Body.Bytes())
+	switch encoding := recorder.Header().Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	case "br":
+		reader = brotli.NewReader(reader)
+	default:
+		return nil, errors.New("unexpected content encoding " + encoding)
+	}
+	return ioutil.ReadAll(reader)
+}
+
 func newRequest(t *testing.T, method, urlStr string, body io.Reader) *http.Request {
 	request, err := http.NewRequest(method, urlStr, body)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// As a server would, keep the URI the way it was asked for.
+	request.RequestURI = urlStr
 	return request
 }
 
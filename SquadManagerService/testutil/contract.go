@@ -0,0 +1,338 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contract is the OpenAPI document a handler serves, against which every request and response made through a Tester
+// is checked.
+type contract struct {
+	document map[string]interface{}
+}
+
+// contractSource loads the contract of a handler the first time it is needed, sharing it between a Tester and the
+// testers derived from it.
+type contractSource struct {
+	once     sync.Once
+	contract *contract
+}
+
+func (source *contractSource) load(handler http.Handler) *contract {
+	source.once.Do(func() {
+		source.contract = loadContract(handler)
+	})
+	return source.contract
+}
+
+// loadContract asks the handler for its OpenAPI document, returning nil when it serves none, as handlers standing in
+// for the service in tests do not.
+func loadContract(handler http.Handler) *contract {
+	if handler == nil {
+		return nil
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		return nil
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil || document["openapi"] == nil {
+		return nil
+	}
+	return &contract{document}
+}
+
+// operation finds the operation documented for the method and path, or nil when the path is not documented at all.
+// documented tells whether the path is, even when the method is not.
+func (contract *contract) operation(method string, path string) (operation map[string]interface{}, documented bool) {
+	paths, _ := contract.document["paths"].(map[string]interface{})
+	for template, item := range paths {
+		if !matchesTemplate(template, path) {
+			continue
+		}
+		operation, _ := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+		return operation, true
+	}
+	return nil, false
+}
+
+func matchesTemplate(template string, path string) bool {
+	templateSegments := strings.Split(template, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(templateSegments) != len(pathSegments) {
+		return false
+	}
+	for index, segment := range templateSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[index] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != pathSegments[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// check lists the ways the exchange breaks the contract. Requests are only held to it when the service accepted
+// them, so that tests can still send requests that are meant to be turned away.
+func (contract *contract) check(request *http.Request, requestBody []byte, response *httptest.ResponseRecorder, responseBody []byte) []string {
+	operation, documented := contract.operation(request.Method, request.URL.Path)
+	if !documented {
+		return nil
+	}
+	exchange := request.Method + " " + request.URL.Path
+	if operation == nil {
+		if response.Code == http.StatusNotFound || response.Code == http.StatusMethodNotAllowed {
+			return nil
+		}
+		return []string{exchange + " is not documented"}
+	}
+
+	var problems []string
+	if response.Code < http.StatusBadRequest {
+		problems = append(problems, contract.checkRequest(operation, request, requestBody)...)
+	}
+	problems = append(problems, contract.checkResponse(operation, response, responseBody)...)
+	for index, problem := range problems {
+		problems[index] = exchange + ": " + problem
+	}
+	return problems
+}
+
+func (contract *contract) checkRequest(operation map[string]interface{}, request *http.Request, body []byte) []string {
+	var problems []string
+	documentedParameters := map[string]bool{}
+	parameters, _ := operation["parameters"].([]interface{})
+	for _, parameter := range parameters {
+		parameter := contract.resolve(parameter).(map[string]interface{})
+		if parameter["in"] == "query" {
+			documentedParameters[parameter["name"].(string)] = true
+		}
+	}
+	for name := range request.URL.Query() {
+		if !documentedParameters[name] {
+			problems = append(problems, fmt.Sprintf("query parameter %q is not documented", name))
+		}
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || string(body) == "null" {
+		return problems
+	}
+	requestBody, ok := contract.resolve(operation["requestBody"]).(map[string]interface{})
+	if !ok {
+		return append(problems, "the request has a body where none is documented")
+	}
+	schema := requestBody["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"]
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return append(problems, "the request body is not JSON: "+err.Error())
+	}
+	return append(problems, contract.validate(schema, value, "request", true)...)
+}
+
+func (contract *contract) checkResponse(operation map[string]interface{}, recorder *httptest.ResponseRecorder, body []byte) []string {
+	responses := operation["responses"].(map[string]interface{})
+	documented, ok := responses[strconv.Itoa(recorder.Code)]
+	if !ok {
+		documented, ok = responses["default"]
+	}
+	if !ok {
+		return []string{fmt.Sprintf("status %d is not documented", recorder.Code)}
+	}
+
+	content, _ := contract.resolve(documented).(map[string]interface{})["content"].(map[string]interface{})
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if content == nil {
+		return []string{fmt.Sprintf("status %d has a body where none is documented", recorder.Code)}
+	}
+	mediaType, _, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil {
+		return []string{fmt.Sprintf("content type %q cannot be read", recorder.Header().Get("Content-Type"))}
+	}
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("content type %s is not documented for status %d", mediaType, recorder.Code)}
+	}
+
+	switch mediaType {
+	case "application/json":
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return []string{"the response body is not JSON: " + err.Error()}
+		}
+		return contract.validate(media["schema"], value, "response", false)
+	case "application/x-ndjson":
+		var problems []string
+		for index, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+			var value interface{}
+			if err := json.Unmarshal(line, &value); err != nil {
+				return append(problems, "a response line is not JSON: "+err.Error())
+			}
+			problems = append(problems, contract.validate(media["schema"], value, fmt.Sprintf("response line %d", index+1), false)...)
+		}
+		return problems
+	}
+	return nil
+}
+
+// resolve follows a reference to a component of the document.
+func (contract *contract) resolve(value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	reference, ok := object["$ref"].(string)
+	if !ok {
+		return value
+	}
+	var resolved interface{} = contract.document
+	for _, name := range strings.Split(strings.TrimPrefix(reference, "#/"), "/") {
+		resolved = resolved.(map[string]interface{})[name]
+	}
+	return contract.resolve(resolved)
+}
+
+// validate checks a decoded JSON value against a schema, naming where each problem is. Fields are only allowed when
+// documented, and read-only fields are ignored in requests.
+func (contract *contract) validate(schema interface{}, value interface{}, at string, inRequest bool) []string {
+	definition, _ := contract.resolve(schema).(map[string]interface{})
+	if alternatives, ok := definition["anyOf"].([]interface{}); ok {
+		return contract.validateAnyOf(alternatives, value, at, inRequest)
+	}
+	if value == nil {
+		if definition["nullable"] == true {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+
+	switch definition["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{at + " is not an object"}
+		}
+		return contract.validateObject(definition, object, at, inRequest)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{at + " is not an array"}
+		}
+		var problems []string
+		for index, element := range array {
+			problems = append(problems, contract.validate(definition["items"], element, fmt.Sprintf("%s[%d]", at, index), inRequest)...)
+		}
+		return problems
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{at + " is not a string"}
+		}
+		return validateString(definition, text, at)
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return []string{at + " is not an integer"}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{at + " is not a number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + " is not a boolean"}
+		}
+	}
+	return nil
+}
+
+// validateAnyOf accepts a value matching any of the alternatives, or reports why it fails the one it comes closest to.
+func (contract *contract) validateAnyOf(alternatives []interface{}, value interface{}, at string, inRequest bool) []string {
+	var closest []string
+	for index, alternative := range alternatives {
+		problems := contract.validate(alternative, value, at, inRequest)
+		if len(problems) == 0 {
+			return nil
+		}
+		if index == 0 || len(problems) < len(closest) {
+			closest = problems
+		}
+	}
+	return closest
+}
+
+func (contract *contract) validateObject(definition map[string]interface{}, object map[string]interface{}, at string, inRequest bool) []string {
+	properties, hasProperties := definition["properties"].(map[string]interface{})
+	additional, hasAdditional := definition["additionalProperties"]
+	if !hasProperties && !hasAdditional {
+		return nil
+	}
+
+	var problems []string
+	if required, ok := definition["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, present := object[name.(string)]; !present {
+				problems = append(problems, fmt.Sprintf("%s lacks the required field %s", at, name))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, documented := properties[name]
+		if !documented && hasAdditional {
+			property, documented = additional, true
+		}
+		if !documented {
+			problems = append(problems, fmt.Sprintf("%s has the undocumented field %s", at, name))
+			continue
+		}
+		if resolved, _ := contract.resolve(property).(map[string]interface{}); inRequest && resolved["readOnly"] == true {
+			continue
+		}
+		problems = append(problems, contract.validate(property, object[name], at+"."+name, inRequest)...)
+	}
+	return problems
+}
+
+func validateString(definition map[string]interface{}, text string, at string) []string {
+	if values, ok := definition["enum"].([]interface{}); ok {
+		allowed := false
+		for _, value := range values {
+			allowed = allowed || value == text
+		}
+		if !allowed {
+			return []string{fmt.Sprintf("%s is %q, which is not one of %v", at, text, values)}
+		}
+	}
+	if pattern, ok := definition["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(text) {
+		return []string{fmt.Sprintf("%s is %q, which does not match %s", at, text, pattern)}
+	}
+	if definition["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+			return []string{fmt.Sprintf("%s is %q, which is not an RFC 3339 date and time", at, text)}
+		}
+	}
+	return nil
+}
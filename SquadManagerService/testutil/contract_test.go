@@ -0,0 +1,110 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDocument = `{
+	"openapi": "3.0.3",
+	"paths": {
+		"/thing/{id}": {
+			"put": {
+				"parameters": [{"name": "verbose", "in": "query", "schema": {"type": "boolean"}}],
+				"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Thing"}}}},
+				"responses": {
+					"200": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Thing"}}}},
+					"201": {"description": "", "content": {"application/json": {"schema": {"anyOf": [
+						{"$ref": "#/components/schemas/Thing"},
+						{"type": "object", "required": ["ID"], "properties": {"ID": {"type": "string"}}}
+					]}}}},
+					"204": {"description": ""},
+					"400": {"description": "", "content": {"application/json": {"schema": {"type": "object"}}}}
+				}
+			}
+		}
+	},
+	"components": {"schemas": {"Thing": {
+		"type": "object",
+		"required": ["Name"],
+		"properties": {
+			"ID": {"type": "string", "readOnly": true, "pattern": "^[0-9a-f]{24}$"},
+			"Name": {"type": "string", "enum": ["dale", "chip"]},
+			"Tags": {"type": "array", "nullable": true, "items": {"type": "string"}},
+			"Created": {"type": "string", "format": "date-time"}
+		}
+	}}}
+}`
+
+func checkExchange(t *testing.T, target string, requestBody string, code int, contentType string, responseBody string) []string {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(testDocument), &document); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", contentType)
+	recorder.WriteHeader(code)
+	recorder.WriteString(responseBody)
+
+	request := httptest.NewRequest("PUT", target, strings.NewReader(requestBody))
+	return (&contract{document}).check(request, []byte(requestBody), recorder, []byte(responseBody))
+}
+
+func TestContractWillAcceptDocumentedExchanges(t *testing.T) {
+	thing := `{"ID":"","Name":"dale","Tags":null,"Created":"2017-08-01T00:00:00Z"}`
+	saved := `{"ID":"5a0e6b1bd2b0f6f2a5c3a7e2","Name":"dale","Tags":["lead"]}`
+
+	assert.Empty(t, checkExchange(t, "/thing/1?verbose=true", thing, http.StatusOK, "application/json; charset=utf-8", saved))
+	assert.Empty(t, checkExchange(t, "/thing/1", thing, http.StatusNoContent, "application/json", ""))
+	assert.Empty(t, checkExchange(t, "/elsewhere", thing, http.StatusNotFound, "text/plain", "404 page not found"))
+}
+
+func TestContractWillAcceptAnyOfSeveralSchemas(t *testing.T) {
+	assert.Empty(t, checkExchange(t, "/thing/1", "", http.StatusCreated, "application/json", `{"Name":"dale"}`))
+	assert.Empty(t, checkExchange(t, "/thing/1", "", http.StatusCreated, "application/json", `{"ID":"1"}`))
+	assert.Equal(t, []string{
+		"PUT /thing/1: response lacks the required field Name",
+		"PUT /thing/1: response has the undocumented field Age",
+	}, checkExchange(t, "/thing/1", "", http.StatusCreated, "application/json", `{"Age":3}`))
+}
+
+func TestContractWillOnlyHoldAcceptedRequestsToIt(t *testing.T) {
+	rejected := checkExchange(t, "/thing/1?colour=red", `{"Name":"hal"}`, http.StatusBadRequest, "application/json", `{}`)
+	accepted := checkExchange(t, "/thing/1?colour=red", `{"Name":"hal"}`, http.StatusNoContent, "application/json", "")
+
+	assert.Empty(t, rejected)
+	assert.Equal(t, []string{
+		`PUT /thing/1: query parameter "colour" is not documented`,
+		`PUT /thing/1: request.Name is "hal", which is not one of [dale chip]`,
+	}, accepted)
+}
+
+func TestContractWillReportResponsesItDoesNotAllow(t *testing.T) {
+	cases := map[string][]string{
+		"unexpected status": checkExchange(t, "/thing/1", "", http.StatusConflict, "application/json", `{}`),
+		"undocumented body": checkExchange(t, "/thing/1", "", http.StatusNoContent, "application/json", `{"Name":"dale"}`),
+		"wrong type":        checkExchange(t, "/thing/1", "", http.StatusOK, "text/plain", `dale`),
+		"undocumented field": checkExchange(t, "/thing/1", "", http.StatusOK, "application/json",
+			`{"Name":"dale","Age":3}`),
+		"invalid values": checkExchange(t, "/thing/1", "", http.StatusOK, "application/json",
+			`{"ID":"","Tags":[1],"Created":"yesterday"}`),
+	}
+
+	assert.Equal(t, map[string][]string{
+		"unexpected status":  {"PUT /thing/1: status 409 is not documented"},
+		"undocumented body":  {"PUT /thing/1: status 204 has a body where none is documented"},
+		"wrong type":         {"PUT /thing/1: content type text/plain is not documented for status 200"},
+		"undocumented field": {"PUT /thing/1: response has the undocumented field Age"},
+		"invalid values": {
+			"PUT /thing/1: response lacks the required field Name",
+			`PUT /thing/1: response.Created is "yesterday", which is not an RFC 3339 date and time`,
+			`PUT /thing/1: response.ID is "", which does not match ^[0-9a-f]{24}$`,
+			"PUT /thing/1: response.Tags[0] is not a string",
+		},
+	}, cases)
+}